@@ -0,0 +1,270 @@
+// Package api holds the OpenAPI document of the HTTP API and validates requests against it
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Served on /openapi.json, every route of the server is documented in it
+//
+//go:embed openapi.json
+var document []byte
+
+// Context key of the schema of a multipart body, used by Form
+const FORM_SCHEMA_KEY = "api.formSchema"
+
+// Part of a schema the validation understands
+type schema struct {
+	Type                 string             `json:"type"`
+	Format               string             `json:"format"`
+	Enum                 []string           `json:"enum"`
+	Pattern              string             `json:"pattern"`
+	Items                *schema            `json:"items"`
+	Properties           map[string]*schema `json:"properties"`
+	Required             []string           `json:"required"`
+	AdditionalProperties any                `json:"additionalProperties"`
+
+	pattern *regexp.Regexp
+}
+
+type parameter struct {
+	Ref      string `json:"$ref"`
+	Name     string `json:"name"`
+	In       string `json:"in"`
+	Required bool   `json:"required"`
+	Schema   schema `json:"schema"`
+}
+
+type operation struct {
+	Parameters  []*parameter `json:"parameters"`
+	RequestBody *struct {
+		Required bool `json:"required"`
+		Content  map[string]struct {
+			Schema schema `json:"schema"`
+		} `json:"content"`
+	} `json:"requestBody"`
+}
+
+// Operations of the document by path and lower case method
+type Spec struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Parameters map[string]*parameter `json:"parameters"`
+	} `json:"components"`
+
+	operations map[string]*operation
+}
+
+// Invalid part of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	In      string `json:"in"`
+	Message string `json:"message"`
+}
+
+// Request that does not match the document, answered with 400
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (err *ValidationError) Error() string {
+	return "Invalid request: " + err.Errors[0].Field + " " + err.Errors[0].Message
+}
+
+// JSON error body listing the invalid fields
+func (err *ValidationError) Body() gin.H {
+	return gin.H{
+		"message": err.Error(),
+		"errors":  err.Errors,
+	}
+}
+
+// Parses the embedded document
+func Load() (*Spec, error) {
+	var spec Spec
+	if err := json.Unmarshal(document, &spec); err != nil {
+		return nil, fmt.Errorf("openapi.json: %w", err)
+	}
+	spec.operations = make(map[string]*operation)
+	for path, item := range spec.Paths {
+		for method, raw := range item {
+			if method == "parameters" {
+				continue
+			}
+			var operation operation
+			if err := json.Unmarshal(raw, &operation); err != nil {
+				return nil, fmt.Errorf("openapi.json: %s %s: %w", method, path, err)
+			}
+			if err := spec.resolve(&operation); err != nil {
+				return nil, fmt.Errorf("openapi.json: %s %s: %w", method, path, err)
+			}
+			spec.operations[strings.ToUpper(method)+" "+path] = &operation
+		}
+	}
+	return &spec, nil
+}
+
+// Resolves references to shared parameters and compiles the patterns
+func (spec *Spec) resolve(operation *operation) error {
+	for i, parameter := range operation.Parameters {
+		if parameter.Ref != "" {
+			shared, ok := spec.Components.Parameters[strings.TrimPrefix(parameter.Ref, "#/components/parameters/")]
+			if !ok {
+				return fmt.Errorf("unknown parameter %s", parameter.Ref)
+			}
+			operation.Parameters[i] = shared
+		}
+		if err := compile(&operation.Parameters[i].Schema); err != nil {
+			return err
+		}
+	}
+	if operation.RequestBody != nil {
+		for contentType, content := range operation.RequestBody.Content {
+			if err := compile(&content.Schema); err != nil {
+				return err
+			}
+			operation.RequestBody.Content[contentType] = content
+		}
+	}
+	return nil
+}
+
+func compile(schema *schema) error {
+	if schema.Pattern != "" {
+		pattern, err := regexp.Compile(schema.Pattern)
+		if err != nil {
+			return err
+		}
+		schema.pattern = pattern
+	}
+	for _, property := range schema.Properties {
+		if err := compile(property); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Operation of the route, e.g. "GET /file/{name}" for the gin route /file/:name
+func (spec *Spec) find(method string, route string) *operation {
+	parts := strings.Split(route, "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") || strings.HasPrefix(part, "*") {
+			parts[i] = "{" + part[1:] + "}"
+		}
+	}
+	return spec.operations[method+" "+strings.Join(parts, "/")]
+}
+
+// Whether the gin route is part of the document
+func (spec *Spec) Documents(method string, route string) bool {
+	return spec.find(method, route) != nil
+}
+
+// Rejects requests that do not match the document with 400 and the invalid fields.
+// Routes missing from the document are not checked
+func (spec *Spec) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		operation := spec.find(c.Request.Method, c.FullPath())
+		if operation == nil {
+			c.Next()
+			return
+		}
+		if errs := operation.validate(c); len(errs) > 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, (&ValidationError{Errors: errs}).Body())
+			return
+		}
+		c.Next()
+	}
+}
+
+// Serves the document
+func Handler(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", document)
+}
+
+func (operation *operation) validate(c *gin.Context) []FieldError {
+	request := c.Request
+	var errs []FieldError
+	query := request.URL.Query()
+	for _, parameter := range operation.Parameters {
+		var values []string
+		switch parameter.In {
+		case "query":
+			values = query[parameter.Name]
+		case "header":
+			values = request.Header.Values(parameter.Name)
+		default:
+			// Path parameters are always there, as the route matched
+			continue
+		}
+		if len(values) == 0 {
+			if parameter.Required {
+				errs = append(errs, FieldError{Field: parameter.Name, In: parameter.In, Message: "is required"})
+			}
+			continue
+		}
+		for _, value := range values {
+			if message := parameter.Schema.check(value); message != "" {
+				errs = append(errs, FieldError{Field: parameter.Name, In: parameter.In, Message: message})
+			}
+		}
+	}
+	if operation.RequestBody != nil {
+		errs = append(errs, operation.validateForm(c)...)
+	}
+	return errs
+}
+
+// Checks that a multipart form is sent. The parts are validated by Form while the handler streams them
+func (operation *operation) validateForm(c *gin.Context) []FieldError {
+	content, ok := operation.RequestBody.Content["multipart/form-data"]
+	if !ok {
+		return nil
+	}
+	mediaType, params, _ := mime.ParseMediaType(c.Request.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return []FieldError{{Field: "Content-Type", In: "header", Message: "must be multipart/form-data with a boundary"}}
+	}
+	c.Set(FORM_SCHEMA_KEY, &content.Schema)
+	return nil
+}
+
+// Whether the form field holds a file, or several files for arrays of them
+func (schema *schema) isFile() bool {
+	if schema.Type == "array" && schema.Items != nil {
+		return schema.Items.isFile()
+	}
+	return schema.Format == "binary"
+}
+
+// Problem with the value, empty if it is valid
+func (schema *schema) check(value string) string {
+	switch schema.Type {
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return "must be true or false"
+		}
+	case "integer":
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return "must be an integer"
+		}
+	}
+	if len(schema.Enum) > 0 && !slices.Contains(schema.Enum, value) {
+		return "must be one of " + strings.Join(schema.Enum, ", ")
+	}
+	if schema.pattern != nil && !schema.pattern.MatchString(value) {
+		return fmt.Sprintf("%q does not match %s", value, schema.Pattern)
+	}
+	return ""
+}
@@ -0,0 +1,152 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func testRouter(t *testing.T) *gin.Engine {
+	spec, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(spec.Middleware())
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.POST("/upload/file", readForm)
+	router.POST("/undocumented", readForm)
+	router.POST("/admin/gc", ok)
+	router.GET("/file/:name", ok)
+	router.PUT("/file/:name", ok)
+	return router
+}
+
+// Streams the form like the upload handler, answering the first invalid part with 400
+func readForm(c *gin.Context) {
+	form, err := NewForm(c)
+	for err == nil {
+		var part *multipart.Part
+		if part, err = form.NextPart(); err == nil && part.FileName() == "" {
+			_, err = form.Value(part)
+		}
+	}
+	var invalid *ValidationError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, invalid.Body())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func uploadRequest(fields map[string]string, file string) *http.Request {
+	return formRequest(fields, file, nil)
+}
+
+// Form with the fields before the file and the trailing fields after it
+func formRequest(fields map[string]string, file string, trailing map[string]string) *http.Request {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	if file != "" {
+		part, _ := form.CreateFormFile(file, "report.pdf")
+		part.Write([]byte("content"))
+	}
+	for name, value := range trailing {
+		form.WriteField(name, value)
+	}
+	form.Close()
+	request := httptest.NewRequest(http.MethodPost, "/upload/file", &body)
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	return request
+}
+
+// Form with a file part for each field
+func filesRequest(fields ...string) *http.Request {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for _, field := range fields {
+		part, _ := form.CreateFormFile(field, "report.pdf")
+		part.Write([]byte("content"))
+	}
+	form.Close()
+	request := httptest.NewRequest(http.MethodPost, "/upload/file", &body)
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	return request
+}
+
+func putRequest(header string, value string) *http.Request {
+	request := httptest.NewRequest(http.MethodPut, "/file/report.pdf", strings.NewReader("content"))
+	request.Header.Set(header, value)
+	return request
+}
+
+// Form with the same field twice, sent to a route missing from the document
+func repeatedFieldRequest(field string) *http.Request {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField(field, "first")
+	form.WriteField(field, "second")
+	form.Close()
+	request := httptest.NewRequest(http.MethodPost, "/undocumented", &body)
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	return request
+}
+
+func TestMiddleware(t *testing.T) {
+	router := testRouter(t)
+	tests := []struct {
+		name    string
+		request *http.Request
+		field   string
+	}{
+		{"Upload", uploadRequest(map[string]string{"chunk-size": "64MB", "legal-hold": "true"}, "upload"), ""},
+		{"Several files", filesRequest("upload", "upload", "archive"), ""},
+		{"Archive sent as field", uploadRequest(map[string]string{"archive": "files.tar"}, ""), "archive"},
+		{"Misspelled file field", uploadRequest(nil, "file"), "file"},
+		{"Chunk size format", uploadRequest(map[string]string{"chunk-size": "64"}, "upload"), "chunk-size"},
+		{"Unknown field", uploadRequest(map[string]string{"chunksize": "64MB"}, "upload"), "chunksize"},
+		{"Chunk size after the file", formRequest(nil, "upload", map[string]string{"chunk-size": "64MB"}), ""},
+		{"Chunk size format after the file", formRequest(nil, "upload", map[string]string{"chunk-size": "64"}), "chunk-size"},
+		{"Chunk size twice", formRequest(map[string]string{"chunk-size": "64MB"}, "upload", map[string]string{"chunk-size": "1MB"}), "chunk-size"},
+		{"Retention mode", uploadRequest(map[string]string{"retention-mode": "forever"}, "upload"), "retention-mode"},
+		{"Unknown field twice on an undocumented route", repeatedFieldRequest("comment"), ""},
+		{"Not a form", httptest.NewRequest(http.MethodPost, "/upload/file", strings.NewReader("content")), "Content-Type"},
+		{"Dry run", httptest.NewRequest(http.MethodPost, "/admin/gc?dry-run=maybe", nil), "dry-run"},
+		{"Download", httptest.NewRequest(http.MethodGet, "/file/report.pdf?version=abc", nil), ""},
+		{"Put", putRequest("X-Chunk-Size", "64MB"), ""},
+		{"Put chunk size", putRequest("X-Chunk-Size", "64"), "X-Chunk-Size"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, tt.request)
+			if tt.field == "" {
+				if w.Code != http.StatusOK {
+					t.Errorf("status = %d, want 200, body %s", w.Code, w.Body)
+				}
+				return
+			}
+			var body struct {
+				Message string       `json:"message"`
+				Errors  []FieldError `json:"errors"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, body %s, want 400 with JSON error", w.Code, w.Body)
+			}
+			if body.Message == "" || len(body.Errors) == 0 || body.Errors[0].Field != tt.field {
+				t.Errorf("errors = %+v, want error for %s", body.Errors, tt.field)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"io"
+	"mime/multipart"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Largest value of a form field that is not a file
+const MAX_FIELD_SIZE = 4096
+
+// Multipart form read part by part, so files are never buffered.
+// Every part is validated against the document when it arrives
+type Form struct {
+	reader *multipart.Reader
+	schema *schema
+	seen   map[string]bool
+}
+
+// Reads the multipart body of the request. Forms of routes missing from the document are not validated
+func NewForm(c *gin.Context) (*Form, error) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, &ValidationError{Errors: []FieldError{{Field: "Content-Type", In: "header", Message: "must be multipart/form-data with a boundary"}}}
+	}
+	form := &Form{reader: reader, schema: &schema{}, seen: make(map[string]bool)}
+	if value, ok := c.Get(FORM_SCHEMA_KEY); ok {
+		form.schema = value.(*schema)
+	}
+	return form, nil
+}
+
+// Next part of the form, io.EOF after the last one.
+// Returns *ValidationError for unknown fields, files sent as fields or the other way around, fields sent twice unless they are arrays
+// and, after the last part, missing required fields
+func (form *Form) NextPart() (*multipart.Part, error) {
+	part, err := form.reader.NextPart()
+	if err == io.EOF {
+		return nil, form.missing()
+	}
+	if err != nil {
+		return nil, err
+	}
+	name := part.FormName()
+	property, known := form.schema.Properties[name]
+	isFile := part.FileName() != ""
+	var message string
+	switch {
+	case !known && form.schema.AdditionalProperties == false:
+		message = "is not a known field"
+	case known && property.isFile() && !isFile:
+		message = "must be a file"
+	case known && !property.isFile() && isFile:
+		message = "must not be a file"
+	case known && form.seen[name] && property.Type != "array":
+		message = "must be given once"
+	}
+	if message != "" {
+		part.Close()
+		return nil, &ValidationError{Errors: []FieldError{{Field: name, In: "body", Message: message}}}
+	}
+	form.seen[name] = true
+	return part, nil
+}
+
+// Reads the value of a field that is not a file and checks it against the document
+func (form *Form) Value(part *multipart.Part) (string, error) {
+	defer part.Close()
+	data, err := io.ReadAll(io.LimitReader(part, MAX_FIELD_SIZE+1))
+	if err != nil {
+		return "", err
+	}
+	name := part.FormName()
+	if len(data) > MAX_FIELD_SIZE {
+		return "", &ValidationError{Errors: []FieldError{{Field: name, In: "body", Message: "is too long"}}}
+	}
+	if property, ok := form.schema.Properties[name]; ok {
+		if message := property.check(string(data)); message != "" {
+			return "", &ValidationError{Errors: []FieldError{{Field: name, In: "body", Message: message}}}
+		}
+	}
+	return string(data), nil
+}
+
+// Error for required fields that were not sent, io.EOF if the form is complete
+func (form *Form) missing() error {
+	var errs []FieldError
+	for _, name := range form.schema.Required {
+		if !form.seen[name] {
+			errs = append(errs, FieldError{Field: name, In: "body", Message: "is required"})
+		}
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return io.EOF
+}
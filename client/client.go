@@ -3,135 +3,385 @@ package client
 import (
 	"context"
 	"encoding/hex"
+	"errors"
 	"io"
-	"log"
+	"log/slog"
+	"sync/atomic"
+	"taurus-minio/logging"
+	"taurus-minio/metrics"
+	"time"
 
-	"github.com/BurntSushi/toml"
 	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
-type Config struct {
-	Minio MinioConfiguration
-}
-
-type MinioConfiguration struct {
-	Endpoint        string
-	AccessKeyID     string
-	SecretAccessKey string
-	UseSSL          bool
-	BucketName      string
-	EncryptionKey   string
-	Chunking        bool
-}
-
 type MinioClient struct {
-	client        *minio.Client
+	client *minio.Client
+	// Connection settings the client was created with, they never change
 	configuration *MinioConfiguration
-	ctx           context.Context
-}
-
-func readConfiguration() *MinioConfiguration {
-	// Read the TOML file
-	var conf Config
-	_, err := toml.DecodeFile("config.toml", &conf)
-	if err != nil {
-		log.Fatalln(err)
-	}
-
-	return &conf.Minio
+	// Current configuration, swapped on reload
+	config  atomic.Pointer[Config]
+	breaker circuitBreaker
 }
 
-func CreateMinioClient() *MinioClient {
+// Creates the client for the given configuration, which must be valid
+func CreateMinioClient(config *Config) *MinioClient {
 	// Create context
 	ctx := context.Background()
-	// Read configuration
-	conf := readConfiguration()
+	conf := &config.Minio
+	_minioClient := MinioClient{
+		configuration: conf,
+	}
+	_minioClient.config.Store(config)
+
+	transport, err := newTransport(conf)
+	if err != nil {
+		logging.Fatal(ctx, "Failed to set up the connection to minio", "error", err)
+	}
+	creds, err := newCredentials(&_minioClient, transport)
+	if err != nil {
+		logging.Fatal(ctx, "Failed to set up minio credentials", "error", err)
+	}
 	// Initialize minio client object.
 	minioClient, err := minio.New(conf.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(conf.AccessKeyID, conf.SecretAccessKey, ""),
-		Secure: conf.UseSSL,
+		Creds:        creds,
+		Secure:       conf.UseSSL,
+		Transport:    transport,
+		Region:       conf.Region,
+		BucketLookup: bucketLookupTypes[conf.BucketLookup],
 	})
 	if err != nil {
-		log.Fatalln(err)
+		logging.Fatal(ctx, "Failed to create minio client", "error", err)
 	}
 
-	log.Printf("%#v\n", minioClient) // minioClient is now setup
+	// minioClient is now setup. Never log the configuration as is, it holds the keys
+	slog.Info("Created minio client", "endpoint", conf.Endpoint, "bucket", conf.BucketName, "useSSL", conf.UseSSL, "region", conf.Region)
 
-	// Build MinioClient
-	_minioClient := MinioClient{
-		client:        minioClient,
-		configuration: conf,
-		ctx:           ctx,
-	}
+	_minioClient.client = minioClient
 	return &_minioClient
 }
 
+func (minioClient *MinioClient) UseObjectLocking() bool {
+	return minioClient.configuration.ObjectLocking
+}
+
 func (minioClient *MinioClient) UseChunking() bool {
-	return minioClient.configuration.Chunking
+	return minioClient.config.Load().Minio.Chunking
+}
+
+func (minioClient *MinioClient) GetScrubberConfiguration() ScrubberConfiguration {
+	return minioClient.config.Load().Scrubber
+}
+
+func (minioClient *MinioClient) GetGCConfiguration() GCConfiguration {
+	return minioClient.config.Load().GC
+}
+
+func (minioClient *MinioClient) GetTrashConfiguration() TrashConfiguration {
+	return minioClient.config.Load().Trash
+}
+
+func (minioClient *MinioClient) GetTracingConfiguration() TracingConfiguration {
+	return minioClient.config.Load().Tracing
+}
+
+func (minioClient *MinioClient) GetLoggingConfiguration() LoggingConfiguration {
+	return minioClient.config.Load().Logging
+}
+
+func (minioClient *MinioClient) GetTLSConfiguration() TLSConfiguration {
+	return minioClient.config.Load().TLS
+}
+
+func (minioClient *MinioClient) GetServerConfiguration() ServerConfiguration {
+	return minioClient.config.Load().Server
 }
 
 func (minioClient *MinioClient) GetEncryptionKey() []byte {
-	key, err := hex.DecodeString(minioClient.configuration.EncryptionKey)
+	key, err := hex.DecodeString(minioClient.config.Load().Minio.EncryptionKey)
 	if err != nil {
-		log.Fatalln(err)
+		logging.Fatal(context.Background(), "Encryption key is not valid hex", "error", err)
 	}
 	return key
 }
 
-func (minioClient *MinioClient) CreateBucket() {
+// Keys of earlier rotations, needed to read files encrypted with them
+func (minioClient *MinioClient) GetPreviousEncryptionKeys() [][]byte {
+	var keys [][]byte
+	for _, previous := range minioClient.config.Load().Minio.PreviousEncryptionKeys {
+		key, err := hex.DecodeString(previous)
+		if err != nil {
+			logging.Fatal(context.Background(), "Previous encryption key is not valid hex", "error", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (minioClient *MinioClient) CreateBucket(ctx context.Context) {
 	// Pass empty options as we only need name
-	err := minioClient.client.MakeBucket(minioClient.ctx, minioClient.configuration.BucketName, minio.MakeBucketOptions{})
+	// Object locking can only be enabled when the bucket is created
+	start := time.Now()
+	err := minioClient.client.MakeBucket(ctx, minioClient.configuration.BucketName, minio.MakeBucketOptions{ObjectLocking: minioClient.configuration.ObjectLocking})
+	observe("MakeBucket", start, err)
 	if err != nil {
-		log.Printf("Failed to create bucket. Checking if %s bucket exists", minioClient.configuration.BucketName)
+		slog.Info("Failed to create bucket, checking if it exists", "bucket", minioClient.configuration.BucketName)
 		// Check to see if we already own this bucket (which happens if you run this twice)
-		exists, errBucketExists := minioClient.client.BucketExists(minioClient.ctx, minioClient.configuration.BucketName)
+		start = time.Now()
+		exists, errBucketExists := minioClient.client.BucketExists(ctx, minioClient.configuration.BucketName)
+		observe("BucketExists", start, errBucketExists)
 		if errBucketExists == nil && exists {
-			log.Printf("We already own %s\n", minioClient.configuration.BucketName)
+			slog.Info("We already own the bucket", "bucket", minioClient.configuration.BucketName)
 		} else {
-			log.Fatalln(err)
+			logging.Fatal(ctx, "Failed to create bucket", "bucket", minioClient.configuration.BucketName, "error", err)
 		}
 	} else {
-		log.Printf("Successfully created %s\n", minioClient.configuration.BucketName)
+		slog.Info("Successfully created bucket", "bucket", minioClient.configuration.BucketName)
 	}
 
 }
 
+// Checks that minio is reachable and the configured bucket exists
+func (minioClient *MinioClient) BucketExists(ctx context.Context) (bool, error) {
+	var exists bool
+	err := minioClient.retry(ctx, "BucketExists", true, func() error {
+		start := time.Now()
+		var err error
+		exists, err = minioClient.client.BucketExists(ctx, minioClient.configuration.BucketName)
+		observe("BucketExists", start, err)
+		return err
+	})
+	return exists, err
+}
+
 func (minioClient *MinioClient) IsOnline() bool {
 	return minioClient.client.IsOnline()
 }
 
-func (minioClient *MinioClient) DownloadFile(name string) *minio.Object {
-	reader, err := minioClient.client.GetObject(minioClient.ctx, minioClient.configuration.BucketName, name, minio.GetObjectOptions{})
+// Opens the object for reading. Minio only sends the request on the first Read or Stat,
+// so it is sent here by Stat and retried if it fails. Errors while reading the body are returned by Read
+func (minioClient *MinioClient) DownloadFile(ctx context.Context, name string) (*minio.Object, error) {
+	var reader *minio.Object
+	err := minioClient.retry(ctx, "GetObject", true, func() error {
+		start := time.Now()
+		object, err := minioClient.client.GetObject(ctx, minioClient.configuration.BucketName, name, minio.GetObjectOptions{})
+		if err == nil {
+			if _, err = object.Stat(); err != nil {
+				object.Close()
+			}
+		}
+		observe("GetObject", start, err)
+		if err == nil {
+			reader = object
+		}
+		return err
+	})
+	return reader, err
+}
+
+// Max 1000chunks
+func (minioClient *MinioClient) GetAllChunks(ctx context.Context, name string) ([]string, error) {
+	chunkName := name + "_"
+	var chunks []string
+	err := minioClient.retry(ctx, "ListObjects", true, func() error {
+		start := time.Now()
+		objectCh := minioClient.client.ListObjects(ctx, minioClient.configuration.BucketName, minio.ListObjectsOptions{Prefix: chunkName})
+
+		chunks = make([]string, 0)
+		for object := range objectCh {
+			if object.Err != nil {
+				observe("ListObjects", start, object.Err)
+				return object.Err
+			}
+			chunks = append(chunks, object.Key)
+		}
+		observe("ListObjects", start, nil)
+		return nil
+	})
+	return chunks, err
+}
+
+func (minioClient *MinioClient) UploadFile(ctx context.Context, file io.Reader, fileName string) (minio.UploadInfo, error) {
+	return minioClient.UploadLockedFile(ctx, file, fileName, nil)
+}
+
+// Same as UploadFile, but protects the object with the given lock.
+// Uploads are only retried if file can seek, e.g. a file on disk, a stream can only be sent once
+func (minioClient *MinioClient) UploadLockedFile(ctx context.Context, file io.Reader, fileName string, lock *ObjectLock) (minio.UploadInfo, error) {
+	opts := minio.PutObjectOptions{ContentType: "application/octet-stream"}
+	lock.apply(&opts)
+	info, err := minioClient.putObject(ctx, file, -1, fileName, opts)
 	if err != nil {
-		log.Fatalf("Error downloading image %s, error: %s\n", name, err)
+		slog.Error("Error uploading object", "object", fileName, "error", err)
+		return info, err
 	}
+	slog.Debug("Successfully uploaded object", "object", fileName, "size", info.Size)
+	return info, nil
+}
 
-	return reader
+// Lists every object under prefix, including the ones in "subfolders"
+func (minioClient *MinioClient) ListObjects(ctx context.Context, prefix string) ([]minio.ObjectInfo, error) {
+	var objects []minio.ObjectInfo
+	err := minioClient.retry(ctx, "ListObjects", true, func() error {
+		start := time.Now()
+		objectCh := minioClient.client.ListObjects(ctx, minioClient.configuration.BucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true})
+
+		objects = make([]minio.ObjectInfo, 0)
+		for object := range objectCh {
+			if object.Err != nil {
+				observe("ListObjects", start, object.Err)
+				return object.Err
+			}
+			objects = append(objects, object)
+		}
+		observe("ListObjects", start, nil)
+		return nil
+	})
+	return objects, err
 }
 
-// Max 1000chunks
-func (minioClient *MinioClient) GetAllChunks(name string) []string {
-	chunkName := name + "_"
-	objectCh := minioClient.client.ListObjects(minioClient.ctx, minioClient.configuration.BucketName, minio.ListObjectsOptions{Prefix: chunkName})
+// Uploads an object of known size and content type as is, without encryption.
+// Used for internal bookkeeping objects such as reports
+func (minioClient *MinioClient) PutObject(ctx context.Context, reader io.Reader, size int64, name string, contentType string) (minio.UploadInfo, error) {
+	return minioClient.PutLockedObject(ctx, reader, size, name, contentType, nil)
+}
+
+// Same as PutObject, but protects the object with the given lock
+func (minioClient *MinioClient) PutLockedObject(ctx context.Context, reader io.Reader, size int64, name string, contentType string, lock *ObjectLock) (minio.UploadInfo, error) {
+	opts := minio.PutObjectOptions{ContentType: contentType}
+	lock.apply(&opts)
+	return minioClient.putObject(ctx, reader, size, name, opts)
+}
+
+// Uploads reader, retrying if it can seek back to where it started. Size -1 means unknown,
+// it is determined for readers that can seek
+func (minioClient *MinioClient) putObject(ctx context.Context, reader io.Reader, size int64, name string, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	seeker, seekable := reader.(io.Seeker)
+	offset := int64(0)
+	if seekable {
+		var err error
+		if offset, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			return minio.UploadInfo{}, err
+		}
+		if size < 0 {
+			end, err := seeker.Seek(0, io.SeekEnd)
+			if err != nil {
+				return minio.UploadInfo{}, err
+			}
+			size = end - offset
+		}
+	}
 
-	chunks := make([]string, 0)
+	var info minio.UploadInfo
+	err := minioClient.retry(ctx, "PutObject", seekable, func() error {
+		if seekable {
+			if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		start := time.Now()
+		var err error
+		info, err = minioClient.client.PutObject(ctx, minioClient.configuration.BucketName, name, reader, size, opts)
+		observe("PutObject", start, err)
+		return err
+	})
+	return info, err
+}
+
+// Reads a whole (small) object into memory.
+// Errors are returned, use IsNotFound to check for missing objects
+func (minioClient *MinioClient) ReadObject(ctx context.Context, name string) ([]byte, error) {
+	var data []byte
+	err := minioClient.retry(ctx, "GetObject", true, func() error {
+		start := time.Now()
+		reader, err := minioClient.client.GetObject(ctx, minioClient.configuration.BucketName, name, minio.GetObjectOptions{})
+		if err != nil {
+			observe("GetObject", start, err)
+			return err
+		}
+		defer reader.Close()
+		data, err = io.ReadAll(reader)
+		observe("GetObject", start, err)
+		return err
+	})
+	return data, err
+}
+
+// Removes a single object from the bucket.
+// Buckets with object locking keep versions, so every version is removed to actually free the space.
+// Fails for versions protected by a lock
+func (minioClient *MinioClient) RemoveObject(ctx context.Context, name string) error {
+	// Removing is idempotent, objects removed by an earlier attempt are simply gone
+	return minioClient.retry(ctx, "RemoveObject", true, func() error {
+		return minioClient.removeObject(ctx, name)
+	})
+}
+
+func (minioClient *MinioClient) removeObject(ctx context.Context, name string) error {
+	if !minioClient.configuration.ObjectLocking {
+		start := time.Now()
+		err := minioClient.client.RemoveObject(ctx, minioClient.configuration.BucketName, name, minio.RemoveObjectOptions{})
+		observe("RemoveObject", start, err)
+		return err
+	}
+	start := time.Now()
+	objectCh := minioClient.client.ListObjects(ctx, minioClient.configuration.BucketName, minio.ListObjectsOptions{Prefix: name, WithVersions: true})
 	for object := range objectCh {
 		if object.Err != nil {
-			log.Fatalln(object.Err)
-			return chunks
+			observe("ListObjectVersions", start, object.Err)
+			return object.Err
+		}
+		if object.Key != name {
+			continue
+		}
+		removeStart := time.Now()
+		err := minioClient.client.RemoveObject(ctx, minioClient.configuration.BucketName, name, minio.RemoveObjectOptions{VersionID: object.VersionID})
+		observe("RemoveObject", removeStart, err)
+		if err != nil {
+			return err
 		}
-		chunks = append(chunks, object.Key)
 	}
-	return chunks
+	observe("ListObjectVersions", start, nil)
+	return nil
 }
 
-func (minioClient *MinioClient) UploadFile(file io.Reader, fileName string) (minio.UploadInfo, error) {
+// Checks if the error returned by minio means the object does not exist
+func IsNotFound(err error) bool {
+	return minio.ToErrorResponse(err).Code == "NoSuchKey"
+}
 
-	info, err := minioClient.client.PutObject(minioClient.ctx, minioClient.configuration.BucketName, fileName, file, -1, minio.PutObjectOptions{ContentType: "application/octet-stream"})
-	if err != nil {
-		log.Fatalln(err)
+// Returns information about the object without reading it
+func (minioClient *MinioClient) StatObject(ctx context.Context, name string) (minio.ObjectInfo, error) {
+	var info minio.ObjectInfo
+	err := minioClient.retry(ctx, "StatObject", true, func() error {
+		start := time.Now()
+		var err error
+		info, err = minioClient.client.StatObject(ctx, minioClient.configuration.BucketName, name, minio.StatObjectOptions{})
+		observe("StatObject", start, err)
+		return err
+	})
+	return info, err
+}
+
+// Copies the object on the server side, works for objects of any size
+func (minioClient *MinioClient) CopyObject(ctx context.Context, source string, destination string) (minio.UploadInfo, error) {
+	src := minio.CopySrcOptions{Bucket: minioClient.configuration.BucketName, Object: source}
+	dst := minio.CopyDestOptions{Bucket: minioClient.configuration.BucketName, Object: destination}
+	// Copying the same source again gives the same destination
+	var info minio.UploadInfo
+	err := minioClient.retry(ctx, "CopyObject", true, func() error {
+		start := time.Now()
+		var err error
+		info, err = minioClient.client.ComposeObject(ctx, dst, src)
+		observe("CopyObject", start, err)
+		return err
+	})
+	return info, err
+}
+
+// Records latency and errors of a minio call. Missing objects and cancelled requests are expected and not counted as errors
+func observe(operation string, start time.Time, err error) {
+	if err != nil && (IsNotFound(err) || errors.Is(err, context.Canceled)) {
+		err = nil
 	}
-	log.Printf("Successfully uploaded %s of size %d\n", fileName, info.Size)
-	return info, nil
+	metrics.ObserveMinio(operation, start, err)
 }
@@ -0,0 +1,674 @@
+package client
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/s3utils"
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration file used when no path is given. It is optional, everything can be set from the environment
+const DEFAULT_CONFIG_FILE = "config.toml"
+
+// Prefix of environment variables overriding the configuration file, e.g. TAURUS_ENDPOINT.
+// Appending _FILE reads the value from the named file instead, e.g. TAURUS_SECRET_ACCESS_KEY_FILE
+const ENV_PREFIX = "TAURUS_"
+
+// Plaintext bytes encrypted as one block if not configured
+const DEFAULT_BLOCK_SIZE = 16384
+
+// Limits of the block size. Every stream keeps a block in memory
+const (
+	MIN_BLOCK_SIZE = 1024
+	MAX_BLOCK_SIZE = 16 * 1024 * 1024
+)
+
+// Smallest chunk size in bytes, checked here as the files package parses the size only per upload
+const MIN_CHUNK_SIZE = 1000
+
+// Format of sizes such as the chunk size, e.g. 64MB
+var sizeRegex = regexp.MustCompile(`^([0-9]+)([KMGTP]?)B$`)
+
+// Bytes of a size such as 64MB, units are powers of 1000. Fails if the size is malformed or too large
+func ParseSize(size string) (uint64, error) {
+	matches := sizeRegex.FindStringSubmatch(size)
+	if matches == nil {
+		return 0, fmt.Errorf("%q must be a number followed by B, KB, MB, GB, TB or PB, e.g. 64MB", size)
+	}
+	number, err := strconv.ParseUint(matches[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is too large", size)
+	}
+	scale := uint64(1)
+	if matches[2] != "" {
+		for i := 0; i <= strings.Index("KMGTP", matches[2]); i++ {
+			scale *= 1000
+		}
+	}
+	if number > math.MaxUint64/scale {
+		return 0, fmt.Errorf("%q is too large", size)
+	}
+	return number * scale, nil
+}
+
+type Config struct {
+	Minio    MinioConfiguration
+	Server   ServerConfiguration
+	Scrubber ScrubberConfiguration
+	GC       GCConfiguration
+	Trash    TrashConfiguration
+	Tracing  TracingConfiguration
+	Logging  LoggingConfiguration
+	TLS      TLSConfiguration
+	Retry    RetryConfiguration
+}
+
+type MinioConfiguration struct {
+	Endpoint        string
+	AccessKeyID     string
+	SecretAccessKey string
+	UseSSL          bool
+	BucketName      string
+	EncryptionKey   string
+	// Keys of earlier rotations, only used to decrypt files written with them
+	PreviousEncryptionKeys []string
+	Chunking               bool
+	// Create the bucket with object locking, required for retention and legal hold
+	ObjectLocking bool
+	// PEM file of CAs trusted for the minio certificate in addition to the system ones
+	CABundle string
+	// PEM files of the certificate and key presented to minio
+	ClientCertificate string
+	ClientKey         string
+	// Name expected in the minio certificate and sent as SNI, defaults to the endpoint host
+	ServerName string
+	// Accepts any minio certificate. Only for development
+	InsecureSkipVerify bool
+	Region             string
+	// How buckets are addressed: auto, dns (virtual host style) or path
+	BucketLookup string
+	Credentials  CredentialsConfiguration
+}
+
+// Where the credentials for minio come from. Providers are tried in order until one returns credentials
+type CredentialsConfiguration struct {
+	// static, env, awsFile, minioFile, serviceAccount, iam, assumeRole, webIdentity or clientGrants.
+	// Defaults to static, accessKeyID and secretAccessKey of the minio section
+	Providers []string
+	// AWS shared credentials file and profile for awsFile, default to ~/.aws/credentials and AWS_PROFILE
+	AWSCredentialsFile string
+	AWSProfile         string
+	// mc configuration and alias for minioFile, default to ~/.mc/config.json and MINIO_ALIAS
+	MinioConfigFile string
+	MinioAlias      string
+	// Service account credentials downloaded from the MinIO console for serviceAccount, e.g. credentials.json
+	ServiceAccountFile string
+	// STS endpoint for assumeRole, webIdentity and clientGrants, e.g. "https://sts.example.com". Defaults to minio itself
+	STSEndpoint string
+	// Role assumed by assumeRole and webIdentity
+	RoleARN         string
+	RoleSessionName string
+	// File with the token (JWT) for webIdentity and clientGrants, read again for every new session
+	TokenFile string
+	// Requested lifetime of the STS credentials. 0 uses the server default
+	Duration time.Duration
+}
+
+// Supported values of MinioConfiguration.BucketLookup, empty means auto
+var bucketLookupTypes = map[string]minio.BucketLookupType{
+	"":     minio.BucketLookupAuto,
+	"auto": minio.BucketLookupAuto,
+	"dns":  minio.BucketLookupDNS,
+	"path": minio.BucketLookupPath,
+}
+
+// Settings of the HTTP server and of the file handling
+type ServerConfiguration struct {
+	// Address the HTTP server listens on, e.g. ":8080"
+	ListenAddress string
+	// Plaintext bytes encrypted as one block. Only applies to new uploads,
+	// every file is read with the block size it was written with
+	BlockSize int
+	// Number of goroutines fetching chunks of a download in parallel
+	DownloadRoutines int
+	// Chunk size of uploads that do not send one, e.g. "64MB"
+	DefaultChunkSize string
+	// How long running requests may take to finish on shutdown before they are cancelled
+	ShutdownTimeout time.Duration
+}
+
+// Settings for the background integrity scrubber
+type ScrubberConfiguration struct {
+	Enabled bool
+	// Time between two full scrubs of the bucket
+	Interval time.Duration
+	// Maximum number of bytes read from minio per second. 0 means unlimited
+	BytesPerSecond int
+}
+
+// Settings for removing data of uploads that never committed a manifest
+type GCConfiguration struct {
+	Enabled bool
+	// Time between two sweeps
+	Interval time.Duration
+	// Unreferenced objects younger than this are never removed
+	GracePeriod time.Duration
+}
+
+// Settings for soft deleted files
+type TrashConfiguration struct {
+	// How long deleted files can be restored
+	Retention time.Duration
+	// Time between two checks for expired files
+	PurgeInterval time.Duration
+}
+
+// Settings for exporting OpenTelemetry traces
+type TracingConfiguration struct {
+	Enabled bool
+	// host:port of the OTLP/HTTP collector
+	Endpoint string
+	// Use plain HTTP to the collector
+	Insecure bool
+	// Share of new traces that are recorded, between 0 and 1
+	SampleRatio float64
+}
+
+// Settings for the JSON application log
+type LoggingConfiguration struct {
+	// debug, info, warn or error
+	Level string
+}
+
+// Settings for retrying failed minio operations and for the circuit breaker
+type RetryConfiguration struct {
+	// Attempts of an operation including the first one. 1 disables retries
+	MaxAttempts int
+	// Delay before the first retry, doubled for every further one up to MaxBackoff. Delays are jittered
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Consecutive failed attempts that open the circuit breaker. 0 disables the breaker
+	BreakerThreshold int
+	// How long the open breaker fails operations before it lets them through again
+	BreakerTimeout time.Duration
+}
+
+// Settings for serving HTTPS and verifying client certificates.
+// The certificate, key and client CA files are reloaded when they change
+type TLSConfiguration struct {
+	Enabled  bool
+	CertFile string
+	KeyFile  string
+	// Oldest accepted protocol version, "1.2" or "1.3"
+	MinVersion string
+	// Names of the accepted TLS 1.2 cipher suites, e.g. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256". Empty uses the Go defaults
+	CipherSuites []string
+	// none, request (verify a certificate if the client sends one) or require
+	ClientAuth string
+	// CA certificates that sign the client certificates
+	ClientCAFile string
+	// Common names or DNS names of the client certificates that may use the API. Empty allows every verified client
+	AllowedClients []string
+}
+
+// Supported values of TLSConfiguration.MinVersion
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Supported values of TLSConfiguration.ClientAuth
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"none":    tls.NoClientCert,
+	"request": tls.VerifyClientCertIfGiven,
+	"require": tls.RequireAndVerifyClientCert,
+}
+
+// Protocol version of MinVersion
+func (conf TLSConfiguration) Version() uint16 {
+	return tlsVersions[conf.MinVersion]
+}
+
+// Client certificate verification of ClientAuth
+func (conf TLSConfiguration) ClientAuthType() tls.ClientAuthType {
+	return clientAuthTypes[conf.ClientAuth]
+}
+
+// Ids of CipherSuites. Insecure suites are never accepted
+func (conf TLSConfiguration) CipherSuiteIDs() ([]uint16, error) {
+	var ids []uint16
+	for _, name := range conf.CipherSuites {
+		id, ok := cipherSuiteID(name)
+		if !ok {
+			return nil, fmt.Errorf("tls.cipherSuites: unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func cipherSuiteID(name string) (uint16, bool) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+	return 0, false
+}
+
+func defaultConfiguration() *Config {
+	return &Config{
+		Server: ServerConfiguration{
+			ListenAddress:    ":8080",
+			BlockSize:        DEFAULT_BLOCK_SIZE,
+			DownloadRoutines: 8,
+			DefaultChunkSize: "64MB",
+			ShutdownTimeout:  30 * time.Second,
+		},
+		TLS: TLSConfiguration{
+			MinVersion: "1.2",
+			ClientAuth: "none",
+		},
+		Retry: RetryConfiguration{
+			MaxAttempts:      3,
+			InitialBackoff:   100 * time.Millisecond,
+			MaxBackoff:       5 * time.Second,
+			BreakerThreshold: 10,
+			BreakerTimeout:   30 * time.Second,
+		},
+	}
+}
+
+// Reads the configuration file, applies the TAURUS_* environment variables and validates the result.
+// The format is chosen by the file extension: .toml, .json, .yaml or .yml.
+// An empty path reads DEFAULT_CONFIG_FILE if it exists
+func LoadConfiguration(path string) (*Config, error) {
+	conf := defaultConfiguration()
+
+	optional := path == ""
+	if optional {
+		path = DEFAULT_CONFIG_FILE
+	}
+	if err := decodeFile(path, conf); err != nil {
+		if !optional || !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		slog.Info("No configuration file, using environment only", "path", path)
+	}
+	if err := applyEnvironment(conf); err != nil {
+		return nil, err
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+func decodeFile(path string, conf *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var values map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".toml":
+		return decodeTOML(path, string(data), conf)
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		err = decoder.Decode(&values)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("%s: unknown configuration format, use .toml, .json, .yaml or .yml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	// Other formats are converted to TOML, so every format is decoded by the same rules,
+	// e.g. keys are case insensitive and durations are strings like "1h"
+	var buffer bytes.Buffer
+	if err := toml.NewEncoder(&buffer).Encode(normalize(values)); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return decodeTOML(path, buffer.String(), conf)
+}
+
+func decodeTOML(path string, data string, conf *Config) error {
+	meta, err := toml.Decode(data, conf)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, key := range meta.Undecoded() {
+		slog.Warn("Unknown configuration key", "path", path, "key", key.String())
+	}
+	return nil
+}
+
+// Converts values decoded from JSON or YAML into types the TOML encoder understands
+func normalize(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		result := make(map[string]any, len(v))
+		for key, item := range v {
+			if item != nil {
+				result[key] = normalize(item)
+			}
+		}
+		return result
+	case []any:
+		result := make([]any, 0, len(v))
+		for _, item := range v {
+			result = append(result, normalize(item))
+		}
+		return result
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	default:
+		return v
+	}
+}
+
+// Environment variable overriding a single setting
+type envVariable struct {
+	name string
+	set  func(value string) error
+}
+
+func stringVariable(name string, target *string) envVariable {
+	return envVariable{name, func(value string) error {
+		*target = value
+		return nil
+	}}
+}
+
+func boolVariable(name string, target *bool) envVariable {
+	return envVariable{name, func(value string) error {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be true or false")
+		}
+		*target = parsed
+		return nil
+	}}
+}
+
+func intVariable(name string, target *int) envVariable {
+	return envVariable{name, func(value string) error {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("must be an integer")
+		}
+		*target = parsed
+		return nil
+	}}
+}
+
+// Duration such as 30s or 1h
+func durationVariable(name string, target *time.Duration) envVariable {
+	return envVariable{name, func(value string) error {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("must be a duration such as 30s")
+		}
+		*target = parsed
+		return nil
+	}}
+}
+
+// Comma separated list
+func listVariable(name string, target *[]string) envVariable {
+	return envVariable{name, func(value string) error {
+		*target = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*target = append(*target, item)
+			}
+		}
+		return nil
+	}}
+}
+
+func environmentVariables(conf *Config) []envVariable {
+	return []envVariable{
+		stringVariable("ENDPOINT", &conf.Minio.Endpoint),
+		stringVariable("ACCESS_KEY_ID", &conf.Minio.AccessKeyID),
+		stringVariable("SECRET_ACCESS_KEY", &conf.Minio.SecretAccessKey),
+		boolVariable("USE_SSL", &conf.Minio.UseSSL),
+		stringVariable("BUCKET_NAME", &conf.Minio.BucketName),
+		stringVariable("ENCRYPTION_KEY", &conf.Minio.EncryptionKey),
+		listVariable("PREVIOUS_ENCRYPTION_KEYS", &conf.Minio.PreviousEncryptionKeys),
+		boolVariable("CHUNKING", &conf.Minio.Chunking),
+		boolVariable("OBJECT_LOCKING", &conf.Minio.ObjectLocking),
+		stringVariable("CA_BUNDLE", &conf.Minio.CABundle),
+		stringVariable("CLIENT_CERTIFICATE", &conf.Minio.ClientCertificate),
+		stringVariable("CLIENT_KEY", &conf.Minio.ClientKey),
+		stringVariable("SERVER_NAME", &conf.Minio.ServerName),
+		boolVariable("INSECURE_SKIP_VERIFY", &conf.Minio.InsecureSkipVerify),
+		stringVariable("REGION", &conf.Minio.Region),
+		stringVariable("BUCKET_LOOKUP", &conf.Minio.BucketLookup),
+		listVariable("CREDENTIAL_PROVIDERS", &conf.Minio.Credentials.Providers),
+		stringVariable("STS_ENDPOINT", &conf.Minio.Credentials.STSEndpoint),
+		stringVariable("ROLE_ARN", &conf.Minio.Credentials.RoleARN),
+		stringVariable("ROLE_SESSION_NAME", &conf.Minio.Credentials.RoleSessionName),
+		stringVariable("TOKEN_FILE", &conf.Minio.Credentials.TokenFile),
+		durationVariable("CREDENTIALS_DURATION", &conf.Minio.Credentials.Duration),
+		stringVariable("AWS_CREDENTIALS_FILE", &conf.Minio.Credentials.AWSCredentialsFile),
+		stringVariable("AWS_PROFILE", &conf.Minio.Credentials.AWSProfile),
+		stringVariable("MINIO_CONFIG_FILE", &conf.Minio.Credentials.MinioConfigFile),
+		stringVariable("MINIO_ALIAS", &conf.Minio.Credentials.MinioAlias),
+		stringVariable("SERVICE_ACCOUNT_FILE", &conf.Minio.Credentials.ServiceAccountFile),
+		stringVariable("LISTEN_ADDRESS", &conf.Server.ListenAddress),
+		intVariable("BLOCK_SIZE", &conf.Server.BlockSize),
+		intVariable("DOWNLOAD_ROUTINES", &conf.Server.DownloadRoutines),
+		stringVariable("DEFAULT_CHUNK_SIZE", &conf.Server.DefaultChunkSize),
+		durationVariable("SHUTDOWN_TIMEOUT", &conf.Server.ShutdownTimeout),
+		intVariable("RETRY_MAX_ATTEMPTS", &conf.Retry.MaxAttempts),
+		stringVariable("LOG_LEVEL", &conf.Logging.Level),
+	}
+}
+
+// Overrides the configuration with TAURUS_<NAME> or the contents of the file named by TAURUS_<NAME>_FILE
+func applyEnvironment(conf *Config) error {
+	for _, variable := range environmentVariables(conf) {
+		name := ENV_PREFIX + variable.name
+		value, found, err := lookupEnvironment(name)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		if err := variable.set(value); err != nil {
+			return fmt.Errorf("%s %w", name, err)
+		}
+	}
+	return nil
+}
+
+func lookupEnvironment(name string) (string, bool, error) {
+	value, found := os.LookupEnv(name)
+	path, fromFile := os.LookupEnv(name + "_FILE")
+	if found && fromFile {
+		return "", false, fmt.Errorf("set either %s or %s_FILE, not both", name, name)
+	}
+	if !fromFile {
+		return value, found, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("%s_FILE: %w", name, err)
+	}
+	// Secret files usually end with a newline
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+// Checks the configuration and returns every problem found
+func (conf *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	minio := conf.Minio
+	if err := validateEndpoint(minio.Endpoint); err != nil {
+		errs = append(errs, err)
+	}
+	check((minio.AccessKeyID == "") == (minio.SecretAccessKey == ""), "minio.accessKeyID and minio.secretAccessKey must be set together")
+	if err := s3utils.CheckValidBucketName(minio.BucketName); err != nil {
+		errs = append(errs, fmt.Errorf("minio.bucketName %q is not valid: %w", minio.BucketName, err))
+	}
+	check(isValidKey(minio.EncryptionKey), "minio.encryptionKey must be 64 hex characters (32 bytes for AES-256), got %d characters", len(minio.EncryptionKey))
+	for i, previous := range minio.PreviousEncryptionKeys {
+		check(isValidKey(previous), "minio.previousEncryptionKeys[%d] must be 64 hex characters (32 bytes for AES-256), got %d characters", i, len(previous))
+	}
+	usesTLS := minio.CABundle != "" || minio.ClientCertificate != "" || minio.ServerName != "" || minio.InsecureSkipVerify
+	check(minio.UseSSL || !usesTLS, "minio.caBundle, minio.clientCertificate, minio.serverName and minio.insecureSkipVerify require minio.useSSL")
+	check((minio.ClientCertificate == "") == (minio.ClientKey == ""), "minio.clientCertificate and minio.clientKey must be set together")
+	_, ok := bucketLookupTypes[minio.BucketLookup]
+	check(ok, "minio.bucketLookup %q must be auto, dns or path", minio.BucketLookup)
+	errs = append(errs, minio.validateCredentials()...)
+
+	server := conf.Server
+	if _, _, err := net.SplitHostPort(server.ListenAddress); err != nil {
+		errs = append(errs, fmt.Errorf("server.listenAddress %q must be [host]:port: %w", server.ListenAddress, err))
+	}
+	check(server.BlockSize >= MIN_BLOCK_SIZE && server.BlockSize <= MAX_BLOCK_SIZE, "server.blockSize must be between %d and %d, got %d", MIN_BLOCK_SIZE, MAX_BLOCK_SIZE, server.BlockSize)
+	check(server.DownloadRoutines > 0, "server.downloadRoutines must be at least 1, got %d", server.DownloadRoutines)
+	check(server.ShutdownTimeout > 0, "server.shutdownTimeout must be positive, running requests would be cut off at once")
+	if chunkSize, err := ParseSize(server.DefaultChunkSize); err != nil {
+		errs = append(errs, fmt.Errorf("server.defaultChunkSize %w", err))
+	} else {
+		check(chunkSize >= MIN_CHUNK_SIZE, "server.defaultChunkSize %q must be at least %d bytes", server.DefaultChunkSize, MIN_CHUNK_SIZE)
+	}
+
+	check(!conf.Scrubber.Enabled || conf.Scrubber.Interval > 0, "scrubber.interval must be set when the scrubber is enabled")
+	check(conf.Scrubber.BytesPerSecond >= 0, "scrubber.bytesPerSecond must not be negative")
+	check(!conf.GC.Enabled || conf.GC.Interval > 0, "gc.interval must be set when the garbage collection is enabled")
+	check(conf.GC.GracePeriod >= 0, "gc.gracePeriod must not be negative")
+	check(conf.Trash.Retention >= 0, "trash.retention must not be negative")
+	check(!conf.Tracing.Enabled || conf.Tracing.Endpoint != "", "tracing.endpoint must be set when tracing is enabled")
+	check(conf.Tracing.SampleRatio >= 0 && conf.Tracing.SampleRatio <= 1, "tracing.sampleRatio must be between 0 and 1")
+	errs = append(errs, conf.TLS.validate()...)
+	retry := conf.Retry
+	check(retry.MaxAttempts >= 1, "retry.maxAttempts must be at least 1, got %d", retry.MaxAttempts)
+	check(retry.InitialBackoff >= 0 && retry.MaxBackoff >= retry.InitialBackoff, "retry.initialBackoff must not be negative and not be larger than retry.maxBackoff")
+	check(retry.BreakerThreshold >= 0, "retry.breakerThreshold must not be negative")
+	check(retry.BreakerThreshold == 0 || retry.BreakerTimeout > 0, "retry.breakerTimeout must be set when the circuit breaker is enabled")
+	var level slog.Level
+	check(conf.Logging.Level == "" || level.UnmarshalText([]byte(conf.Logging.Level)) == nil, "logging.level %q must be debug, info, warn or error", conf.Logging.Level)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Decoded encryption key followed by the keys of earlier rotations
+func (conf *MinioConfiguration) EncryptionKeys() ([][]byte, error) {
+	var keys [][]byte
+	for _, encoded := range append([]string{conf.EncryptionKey}, conf.PreviousEncryptionKeys...) {
+		key, err := hex.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func isValidKey(key string) bool {
+	decoded, err := hex.DecodeString(key)
+	return err == nil && len(decoded) == 32
+}
+
+// Minio expects host[:port], without scheme or path
+func validateEndpoint(endpoint string) error {
+	if endpoint == "" {
+		return errors.New("minio.endpoint must be set, e.g. localhost:9000")
+	}
+	if strings.Contains(endpoint, "://") || strings.Contains(endpoint, "/") {
+		return fmt.Errorf("minio.endpoint %q must be host[:port] without scheme or path, use minio.useSSL for https", endpoint)
+	}
+	if !strings.Contains(endpoint, ":") {
+		return nil
+	}
+	_, port, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return fmt.Errorf("minio.endpoint %q is not host:port: %w", endpoint, err)
+	}
+	if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+		return fmt.Errorf("minio.endpoint %q has invalid port %q", endpoint, port)
+	}
+	return nil
+}
+
+func (conf TLSConfiguration) validate() []error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	if !conf.Enabled {
+		return nil
+	}
+	check(conf.CertFile != "" && conf.KeyFile != "", "tls.certFile and tls.keyFile must be set when TLS is enabled")
+	_, ok := tlsVersions[conf.MinVersion]
+	check(ok, "tls.minVersion %q must be 1.2 or 1.3", conf.MinVersion)
+	if _, err := conf.CipherSuiteIDs(); err != nil {
+		errs = append(errs, err)
+	}
+	_, ok = clientAuthTypes[conf.ClientAuth]
+	check(ok, "tls.clientAuth %q must be none, request or require", conf.ClientAuth)
+	check(conf.ClientAuth == "none" || conf.ClientCAFile != "", "tls.clientCAFile must be set when client certificates are verified")
+	check(len(conf.AllowedClients) == 0 || conf.ClientAuth != "none", "tls.allowedClients requires client certificates, set tls.clientAuth")
+	return errs
+}
+
+func (conf MinioConfiguration) validateCredentials() []error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	credentials := conf.Credentials
+	seen := make(map[string]bool)
+	for _, provider := range credentials.Providers {
+		check(slices.Contains(CREDENTIAL_PROVIDERS, provider), "minio.credentials.providers: unknown provider %q, use one of %s", provider, strings.Join(CREDENTIAL_PROVIDERS, ", "))
+		check(!seen[provider], "minio.credentials.providers: %q is listed twice", provider)
+		seen[provider] = true
+	}
+	check(!seen["assumeRole"] || conf.AccessKeyID != "", "minio.credentials: assumeRole needs minio.accessKeyID and minio.secretAccessKey")
+	check(!seen["webIdentity"] && !seen["clientGrants"] || credentials.TokenFile != "", "minio.credentials.tokenFile must be set for webIdentity and clientGrants")
+	check(!seen["serviceAccount"] || credentials.ServiceAccountFile != "", "minio.credentials.serviceAccountFile must be set for serviceAccount")
+	check(credentials.STSEndpoint == "" || strings.Contains(credentials.STSEndpoint, "://"), "minio.credentials.stsEndpoint %q must be a URL with scheme", credentials.STSEndpoint)
+	check(credentials.Duration >= 0, "minio.credentials.duration must not be negative")
+	return errs
+}
@@ -0,0 +1,187 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testKey = "6368616e676520746869732070617373776f726420746f206120736563726574"
+
+func writeConfig(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigurationFormats(t *testing.T) {
+	files := map[string]string{
+		"config.toml": `
+[minio]
+endpoint="localhost:9000"
+accessKeyID="access"
+secretAccessKey="secret"
+bucketName="file-storage"
+encryptionKey="` + testKey + `"
+chunking=true
+
+[server]
+downloadRoutines=4
+
+[gc]
+enabled=true
+interval="1h"
+
+[tracing]
+sampleRatio=1
+`,
+		"config.json": `{
+  "minio": {"endpoint": "localhost:9000", "accessKeyID": "access", "secretAccessKey": "secret",
+            "bucketName": "file-storage", "encryptionKey": "` + testKey + `", "chunking": true},
+  "server": {"downloadRoutines": 4},
+  "gc": {"enabled": true, "interval": "1h"},
+  "tracing": {"sampleRatio": 1}
+}`,
+		"config.yaml": `
+minio:
+  endpoint: localhost:9000
+  accessKeyID: access
+  secretAccessKey: secret
+  bucketName: file-storage
+  encryptionKey: "` + testKey + `"
+  chunking: true
+server:
+  downloadRoutines: 4
+gc:
+  enabled: true
+  interval: 1h
+tracing:
+  sampleRatio: 1
+`,
+	}
+
+	var first *Config
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			conf, err := LoadConfiguration(writeConfig(t, name, content))
+			if err != nil {
+				t.Fatalf("LoadConfiguration() error = %v", err)
+			}
+			if conf.Minio.Endpoint != "localhost:9000" || !conf.Minio.Chunking || conf.GC.Interval != time.Hour {
+				t.Errorf("LoadConfiguration() = %+v", conf)
+			}
+			// Defaults stay for settings the file does not mention
+			if conf.Server.DownloadRoutines != 4 || conf.Server.BlockSize != DEFAULT_BLOCK_SIZE || conf.Server.ListenAddress != ":8080" {
+				t.Errorf("LoadConfiguration() server = %+v", conf.Server)
+			}
+			if first == nil {
+				first = conf
+			} else if !reflect.DeepEqual(first, conf) {
+				t.Errorf("formats differ:\n%+v\n%+v", first, conf)
+			}
+		})
+	}
+}
+
+func TestLoadConfigurationEnvironment(t *testing.T) {
+	path := writeConfig(t, "config.toml", `
+[minio]
+endpoint="localhost:9000"
+bucketName="file-storage"
+encryptionKey="`+testKey+`"
+`)
+	secret := writeConfig(t, "secret", "from-file\n")
+	t.Setenv("TAURUS_ENDPOINT", "minio:9000")
+	t.Setenv("TAURUS_ACCESS_KEY_ID", "access")
+	t.Setenv("TAURUS_SECRET_ACCESS_KEY_FILE", secret)
+	t.Setenv("TAURUS_CHUNKING", "true")
+	t.Setenv("TAURUS_BLOCK_SIZE", "65536")
+	t.Setenv("TAURUS_AWS_PROFILE", "backup")
+	t.Setenv("TAURUS_CREDENTIALS_DURATION", "2h")
+	t.Setenv("TAURUS_ROLE_SESSION_NAME_FILE", writeConfig(t, "session", "taurus\n"))
+
+	conf, err := LoadConfiguration(path)
+	if err != nil {
+		t.Fatalf("LoadConfiguration() error = %v", err)
+	}
+	if conf.Minio.Endpoint != "minio:9000" || conf.Minio.AccessKeyID != "access" || !conf.Minio.Chunking {
+		t.Errorf("environment not applied: %+v", conf.Minio)
+	}
+	if conf.Minio.SecretAccessKey != "from-file" {
+		t.Errorf("secret from file = %q", conf.Minio.SecretAccessKey)
+	}
+	if conf.Server.BlockSize != 65536 {
+		t.Errorf("block size = %d", conf.Server.BlockSize)
+	}
+	if credentials := conf.Minio.Credentials; credentials.AWSProfile != "backup" || credentials.Duration != 2*time.Hour || credentials.RoleSessionName != "taurus" {
+		t.Errorf("credentials not applied: %+v", credentials)
+	}
+
+	t.Setenv("TAURUS_SECRET_ACCESS_KEY", "both")
+	if _, err := LoadConfiguration(path); err == nil || !strings.Contains(err.Error(), "not both") {
+		t.Errorf("expected error for variable and file, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *Config {
+		conf := defaultConfiguration()
+		conf.Minio = MinioConfiguration{Endpoint: "localhost:9000", BucketName: "file-storage", EncryptionKey: testKey}
+		return conf
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("Validate() of valid configuration = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(*Config)
+		want   string
+	}{
+		{"Scheme in endpoint", func(c *Config) { c.Minio.Endpoint = "http://localhost:9000" }, "without scheme"},
+		{"Invalid port", func(c *Config) { c.Minio.Endpoint = "localhost:99999" }, "invalid port"},
+		{"Short key", func(c *Config) { c.Minio.EncryptionKey = "abcd" }, "64 hex characters"},
+		{"Key not hex", func(c *Config) { c.Minio.EncryptionKey = strings.Repeat("x", 64) }, "64 hex characters"},
+		{"Only access key", func(c *Config) { c.Minio.AccessKeyID = "access" }, "set together"},
+		{"Bucket name", func(c *Config) { c.Minio.BucketName = "A" }, "bucketName"},
+		{"CA bundle without SSL", func(c *Config) { c.Minio.CABundle = "ca.pem" }, "require minio.useSSL"},
+		{"Only client certificate", func(c *Config) { c.Minio.UseSSL = true; c.Minio.ClientCertificate = "client.crt" }, "set together"},
+		{"Bucket lookup", func(c *Config) { c.Minio.BucketLookup = "virtual" }, "minio.bucketLookup"},
+		{"Unknown credential provider", func(c *Config) { c.Minio.Credentials.Providers = []string{"vault"} }, "unknown provider"},
+		{"Web identity without token", func(c *Config) { c.Minio.Credentials.Providers = []string{"webIdentity"} }, "tokenFile"},
+		{"Block size", func(c *Config) { c.Server.BlockSize = 10 }, "blockSize"},
+		{"Chunk size", func(c *Config) { c.Server.DefaultChunkSize = "64" }, "defaultChunkSize"},
+		{"Small chunk size", func(c *Config) { c.Server.DefaultChunkSize = "999B" }, "at least 1000 bytes"},
+		{"Huge chunk size", func(c *Config) { c.Server.DefaultChunkSize = "99999999999999999PB" }, "defaultChunkSize"},
+		{"Shutdown timeout", func(c *Config) { c.Server.ShutdownTimeout = 0 }, "server.shutdownTimeout"},
+		{"Listen address", func(c *Config) { c.Server.ListenAddress = "8080" }, "listenAddress"},
+		{"Scrubber interval", func(c *Config) { c.Scrubber.Enabled = true }, "scrubber.interval"},
+		{"Retry attempts", func(c *Config) { c.Retry.MaxAttempts = 0 }, "retry.maxAttempts"},
+		{"Retry backoff", func(c *Config) { c.Retry.MaxBackoff = time.Millisecond }, "retry.initialBackoff"},
+		{"TLS without certificate", func(c *Config) { c.TLS.Enabled = true }, "tls.certFile"},
+		{"TLS version", func(c *Config) {
+			c.TLS = TLSConfiguration{Enabled: true, CertFile: "a", KeyFile: "b", MinVersion: "1.1", ClientAuth: "none"}
+		}, "tls.minVersion"},
+		{"Insecure cipher suite", func(c *Config) {
+			c.TLS = TLSConfiguration{Enabled: true, CertFile: "a", KeyFile: "b", MinVersion: "1.2", ClientAuth: "none", CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}
+		}, "tls.cipherSuites"},
+		{"Client auth without CA", func(c *Config) {
+			c.TLS = TLSConfiguration{Enabled: true, CertFile: "a", KeyFile: "b", MinVersion: "1.2", ClientAuth: "require"}
+		}, "tls.clientCAFile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := valid()
+			tt.change(conf)
+			err := conf.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate() = %v, want error containing %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Names of the supported credential providers, see CredentialsConfiguration.Providers
+var CREDENTIAL_PROVIDERS = []string{"static", "env", "awsFile", "minioFile", "serviceAccount", "iam", "assumeRole", "webIdentity", "clientGrants"}
+
+// Builds the credential chain of the configuration.
+// Credentials are cached by minio-go until the provider reports them as expired:
+// STS credentials before they run out, file based ones when the file changes and
+// the static keys when a reload changes them
+func newCredentials(minioClient *MinioClient, transport http.RoundTripper) (*credentials.Credentials, error) {
+	conf := minioClient.configuration
+	names := conf.Credentials.Providers
+	if len(names) == 0 {
+		names = []string{"static"}
+	}
+	var providers []credentials.Provider
+	for _, name := range names {
+		provider, err := newProvider(name, minioClient, transport)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, &namedProvider{name: name, Provider: provider})
+	}
+	return credentials.NewChainCredentials(providers), nil
+}
+
+func newProvider(name string, minioClient *MinioClient, transport http.RoundTripper) (credentials.Provider, error) {
+	conf := minioClient.configuration
+	options := conf.Credentials
+	stsEndpoint := options.STSEndpoint
+	if stsEndpoint == "" {
+		stsEndpoint = "http://" + conf.Endpoint
+		if conf.UseSSL {
+			stsEndpoint = "https://" + conf.Endpoint
+		}
+	}
+	httpClient := &http.Client{Transport: transport}
+
+	switch name {
+	case "static":
+		return &configCredentials{minioClient: minioClient}, nil
+	case "env":
+		return &credentials.Chain{Providers: []credentials.Provider{&credentials.EnvMinio{}, &credentials.EnvAWS{}}}, nil
+	case "awsFile":
+		provider := &credentials.FileAWSCredentials{Filename: options.AWSCredentialsFile, Profile: options.AWSProfile}
+		return &watchedFile{Provider: provider, path: &provider.Filename}, nil
+	case "minioFile":
+		provider := &credentials.FileMinioClient{Filename: options.MinioConfigFile, Alias: options.MinioAlias}
+		return &watchedFile{Provider: provider, path: &provider.Filename}, nil
+	case "serviceAccount":
+		provider := &serviceAccountFile{path: options.ServiceAccountFile}
+		return &watchedFile{Provider: provider, path: &provider.path}, nil
+	case "iam":
+		return &credentials.IAM{Client: httpClient}, nil
+	case "assumeRole":
+		return &credentials.STSAssumeRole{
+			Client:      httpClient,
+			STSEndpoint: stsEndpoint,
+			Options: credentials.STSAssumeRoleOptions{
+				AccessKey:       conf.AccessKeyID,
+				SecretKey:       conf.SecretAccessKey,
+				Location:        conf.Region,
+				DurationSeconds: int(options.Duration.Seconds()),
+				RoleARN:         options.RoleARN,
+				RoleSessionName: options.RoleSessionName,
+			},
+		}, nil
+	case "webIdentity":
+		return &credentials.STSWebIdentity{
+			Client:      httpClient,
+			STSEndpoint: stsEndpoint,
+			RoleARN:     options.RoleARN,
+			GetWebIDTokenExpiry: func() (*credentials.WebIdentityToken, error) {
+				token, err := readToken(options.TokenFile)
+				return &credentials.WebIdentityToken{Token: token, Expiry: int(options.Duration.Seconds())}, err
+			},
+		}, nil
+	case "clientGrants":
+		return &credentials.STSClientGrants{
+			Client:      httpClient,
+			STSEndpoint: stsEndpoint,
+			GetClientGrantsTokenExpiry: func() (*credentials.ClientGrantsToken, error) {
+				token, err := readToken(options.TokenFile)
+				return &credentials.ClientGrantsToken{Token: token, Expiry: int(options.Duration.Seconds())}, err
+			},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown credential provider %q", name)
+}
+
+// Token files are rotated by the platform, e.g. Kubernetes projected service account tokens
+func readToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Logs which provider is used and why the others are skipped.
+// The chain of minio-go drops the errors of the providers
+type namedProvider struct {
+	credentials.Provider
+	name string
+}
+
+func (provider *namedProvider) Retrieve() (credentials.Value, error) {
+	value, err := provider.Provider.Retrieve()
+	if err != nil {
+		slog.Debug("Minio credential provider has no credentials", "provider", provider.name, "error", err)
+		return value, err
+	}
+	if value.AccessKeyID != "" {
+		slog.Info("Retrieved minio credentials", "provider", provider.name)
+	}
+	return value, nil
+}
+
+// Static keys of the current configuration, so keys changed by a reload are used without a restart
+type configCredentials struct {
+	minioClient *MinioClient
+
+	mu        sync.Mutex
+	retrieved credentials.Value
+}
+
+func (provider *configCredentials) keys() (string, string) {
+	conf := provider.minioClient.config.Load().Minio
+	return conf.AccessKeyID, conf.SecretAccessKey
+}
+
+func (provider *configCredentials) Retrieve() (credentials.Value, error) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	accessKey, secretKey := provider.keys()
+	provider.retrieved = credentials.Value{AccessKeyID: accessKey, SecretAccessKey: secretKey, SignerType: credentials.SignatureV4}
+	if accessKey == "" {
+		provider.retrieved.SignerType = credentials.SignatureAnonymous
+	}
+	return provider.retrieved, nil
+}
+
+func (provider *configCredentials) IsExpired() bool {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	accessKey, secretKey := provider.keys()
+	return accessKey != provider.retrieved.AccessKeyID || secretKey != provider.retrieved.SecretAccessKey
+}
+
+// Expires the credentials of a file based provider when the file changes
+type watchedFile struct {
+	credentials.Provider
+	// Path of the file, known once the provider resolved its default
+	path *string
+
+	mu      sync.Mutex
+	modTime time.Time
+}
+
+func (provider *watchedFile) fileModTime() time.Time {
+	if *provider.path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(*provider.path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func (provider *watchedFile) Retrieve() (credentials.Value, error) {
+	value, err := provider.Provider.Retrieve()
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	provider.modTime = provider.fileModTime()
+	return value, err
+}
+
+func (provider *watchedFile) IsExpired() bool {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	return provider.Provider.IsExpired() || !provider.fileModTime().Equal(provider.modTime)
+}
+
+// Service account credentials as downloaded from the MinIO console
+type serviceAccountFile struct {
+	path      string
+	retrieved bool
+}
+
+func (provider *serviceAccountFile) Retrieve() (credentials.Value, error) {
+	provider.retrieved = false
+	data, err := os.ReadFile(provider.path)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	var account struct {
+		AccessKey string `json:"accessKey"`
+		SecretKey string `json:"secretKey"`
+	}
+	if err := json.Unmarshal(data, &account); err != nil {
+		return credentials.Value{}, fmt.Errorf("%s: %w", provider.path, err)
+	}
+	if account.AccessKey == "" || account.SecretKey == "" {
+		return credentials.Value{}, errors.New(provider.path + ": accessKey and secretKey must be set")
+	}
+	provider.retrieved = true
+	return credentials.Value{AccessKeyID: account.AccessKey, SecretAccessKey: account.SecretKey, SignerType: credentials.SignatureV4}, nil
+}
+
+func (provider *serviceAccountFile) IsExpired() bool {
+	return !provider.retrieved
+}
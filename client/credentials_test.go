@@ -0,0 +1,73 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testCredentialsClient(minio MinioConfiguration) *MinioClient {
+	minioClient := &MinioClient{configuration: &minio}
+	minioClient.config.Store(&Config{Minio: minio})
+	return minioClient
+}
+
+func TestStaticCredentialsFollowReload(t *testing.T) {
+	minioClient := testCredentialsClient(MinioConfiguration{Endpoint: "localhost:9000", AccessKeyID: "first", SecretAccessKey: "first-secret"})
+	creds, err := newCredentials(minioClient, nil)
+	if err != nil {
+		t.Fatalf("newCredentials() error = %v", err)
+	}
+	if value, _ := creds.Get(); value.AccessKeyID != "first" {
+		t.Fatalf("Get() = %q, want first", value.AccessKeyID)
+	}
+
+	minioClient.config.Store(&Config{Minio: MinioConfiguration{AccessKeyID: "second", SecretAccessKey: "second-secret"}})
+	if value, _ := creds.Get(); value.AccessKeyID != "second" || value.SecretAccessKey != "second-secret" {
+		t.Errorf("Get() after reload = %q, want second", value.AccessKeyID)
+	}
+}
+
+func TestServiceAccountFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	minioClient := testCredentialsClient(MinioConfiguration{
+		Endpoint:        "localhost:9000",
+		AccessKeyID:     "static",
+		SecretAccessKey: "static-secret",
+		Credentials: CredentialsConfiguration{
+			Providers:          []string{"serviceAccount", "static"},
+			ServiceAccountFile: path,
+		},
+	})
+	creds, err := newCredentials(minioClient, nil)
+	if err != nil {
+		t.Fatalf("newCredentials() error = %v", err)
+	}
+
+	// Falls back to the next provider while the file is missing
+	if value, _ := creds.Get(); value.AccessKeyID != "static" {
+		t.Errorf("Get() without file = %q, want static", value.AccessKeyID)
+	}
+
+	write := func(accessKey string, modTime time.Time) {
+		content := `{"url":"http://localhost:9001/api/v1/service-account-credentials","accessKey":"` + accessKey + `","secretKey":"secret","api":"s3v4","path":"auto"}`
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The static keys are only replaced once they expire, which a new configuration does
+	write("account", time.Now().Add(-time.Hour))
+	minioClient.config.Store(&Config{Minio: MinioConfiguration{AccessKeyID: "changed", SecretAccessKey: "changed-secret"}})
+	if value, _ := creds.Get(); value.AccessKeyID != "account" {
+		t.Errorf("Get() with file = %q, want account", value.AccessKeyID)
+	}
+
+	write("rotated", time.Now())
+	if value, _ := creds.Get(); value.AccessKeyID != "rotated" {
+		t.Errorf("Get() after rotation = %q, want rotated", value.AccessKeyID)
+	}
+}
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Object lock (WORM) settings applied to uploaded objects.
+// Requires a bucket created with object locking enabled
+type ObjectLock struct {
+	// GOVERNANCE or COMPLIANCE, empty for no retention
+	Mode        minio.RetentionMode `json:"mode,omitempty"`
+	RetainUntil time.Time           `json:"retainUntil,omitempty"`
+	LegalHold   bool                `json:"legalHold,omitempty"`
+}
+
+// Checks if the lock still protects the object at this moment
+func (lock *ObjectLock) IsActive() bool {
+	if lock == nil {
+		return false
+	}
+	return lock.LegalHold || (lock.Mode != "" && lock.RetainUntil.After(time.Now()))
+}
+
+// Explains why the object is protected
+func (lock *ObjectLock) String() string {
+	if lock.LegalHold {
+		return "under legal hold"
+	}
+	return fmt.Sprintf("under %s retention until %s", lock.Mode, lock.RetainUntil.Format(time.RFC3339))
+}
+
+// Adds the lock to put options. Expired locks are not applied, as minio rejects retention in the past
+func (lock *ObjectLock) apply(opts *minio.PutObjectOptions) {
+	if !lock.IsActive() {
+		return
+	}
+	if lock.Mode != "" && lock.RetainUntil.After(time.Now()) {
+		opts.Mode = lock.Mode
+		opts.RetainUntilDate = lock.RetainUntil
+	}
+	if lock.LegalHold {
+		opts.LegalHold = minio.LegalHoldEnabled
+	}
+	// Object lock requests must carry a content checksum
+	opts.SendContentMd5 = true
+}
+
+// Reads the current lock of the object from minio. Returns nil if the object is not protected
+func (minioClient *MinioClient) GetObjectLock(ctx context.Context, name string) (*ObjectLock, error) {
+	if !minioClient.configuration.ObjectLocking {
+		return nil, nil
+	}
+	lock := ObjectLock{}
+	start := time.Now()
+	mode, retainUntil, err := minioClient.client.GetObjectRetention(ctx, minioClient.configuration.BucketName, name, "")
+	if isNoLockConfiguration(err) {
+		observe("GetObjectRetention", start, nil)
+	} else {
+		observe("GetObjectRetention", start, err)
+	}
+	if err != nil && !isNoLockConfiguration(err) {
+		return nil, err
+	}
+	if mode != nil && retainUntil != nil {
+		lock.Mode = *mode
+		lock.RetainUntil = *retainUntil
+	}
+	start = time.Now()
+	status, err := minioClient.client.GetObjectLegalHold(ctx, minioClient.configuration.BucketName, name, minio.GetObjectLegalHoldOptions{})
+	if isNoLockConfiguration(err) {
+		observe("GetObjectLegalHold", start, nil)
+	} else {
+		observe("GetObjectLegalHold", start, err)
+	}
+	if err != nil && !isNoLockConfiguration(err) {
+		return nil, err
+	}
+	lock.LegalHold = status != nil && *status == minio.LegalHoldEnabled
+	if !lock.IsActive() {
+		return nil, nil
+	}
+	return &lock, nil
+}
+
+// Protects an object that is already stored with the given lock.
+// Used when the lock is only known after the object was uploaded
+func (minioClient *MinioClient) LockObject(ctx context.Context, name string, lock *ObjectLock) error {
+	if !lock.IsActive() {
+		return nil
+	}
+	// Setting the same lock again is harmless
+	return minioClient.retry(ctx, "LockObject", true, func() error {
+		bucket := minioClient.configuration.BucketName
+		if lock.Mode != "" && lock.RetainUntil.After(time.Now()) {
+			mode, until := lock.Mode, lock.RetainUntil
+			start := time.Now()
+			err := minioClient.client.PutObjectRetention(ctx, bucket, name, minio.PutObjectRetentionOptions{Mode: &mode, RetainUntilDate: &until})
+			observe("PutObjectRetention", start, err)
+			if err != nil {
+				return err
+			}
+		}
+		if lock.LegalHold {
+			status := minio.LegalHoldEnabled
+			start := time.Now()
+			err := minioClient.client.PutObjectLegalHold(ctx, bucket, name, minio.PutObjectLegalHoldOptions{Status: &status})
+			observe("PutObjectLegalHold", start, err)
+			return err
+		}
+		return nil
+	})
+}
+
+// Objects uploaded without retention or legal hold report these instead of an empty configuration
+func isNoLockConfiguration(err error) bool {
+	code := minio.ToErrorResponse(err).Code
+	return code == "NoSuchObjectLockConfiguration" || code == "ObjectLockConfigurationNotFoundError"
+}
@@ -0,0 +1,170 @@
+package client
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// How often the configuration file is checked for changes
+const RELOAD_CHECK_INTERVAL = 5 * time.Second
+
+// Reloads the configuration when the file changes or on SIGHUP.
+// Only settings that are read per request or per run are swapped, see mergeReloadable.
+// Running uploads and downloads keep the settings they started with
+type Reloader struct {
+	minioClient *MinioClient
+	// Path as given to LoadConfiguration, empty for the default file
+	path string
+
+	// Serializes reloads
+	mu        sync.Mutex
+	modTime   time.Time
+	listeners []func(*Config)
+}
+
+// Creates reloader for the configuration of the client, read from path
+func InitReloader(minioClient *MinioClient, path string) *Reloader {
+	reloader := &Reloader{
+		minioClient: minioClient,
+		path:        path,
+	}
+	reloader.modTime, _ = reloader.fileModTime()
+	return reloader
+}
+
+// Registers a function called with the new configuration after every successful reload
+func (reloader *Reloader) OnReload(listener func(*Config)) {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+	reloader.listeners = append(reloader.listeners, listener)
+}
+
+func (reloader *Reloader) fileModTime() (time.Time, error) {
+	path := reloader.path
+	if path == "" {
+		path = DEFAULT_CONFIG_FILE
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// Starts watching the configuration file and listening for SIGHUP
+func (reloader *Reloader) Start() {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		ticker := time.NewTicker(RELOAD_CHECK_INTERVAL)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-hangup:
+				slog.Info("Received SIGHUP, reloading configuration")
+			case <-ticker.C:
+				modTime, err := reloader.fileModTime()
+				reloader.mu.Lock()
+				changed := err == nil && !modTime.Equal(reloader.modTime)
+				reloader.mu.Unlock()
+				if !changed {
+					continue
+				}
+				slog.Info("Configuration file changed, reloading")
+			}
+			if err := reloader.Reload(); err != nil {
+				slog.Error("Failed to reload configuration, keeping the current one", "error", err)
+			}
+		}
+	}()
+}
+
+// Reads and validates the configuration and applies the settings that can change at runtime.
+// Nothing is applied if the configuration is invalid
+func (reloader *Reloader) Reload() error {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+	if modTime, err := reloader.fileModTime(); err == nil {
+		reloader.modTime = modTime
+	}
+
+	loaded, err := LoadConfiguration(reloader.path)
+	if err != nil {
+		return err
+	}
+	merged, rejected := mergeReloadable(reloader.minioClient.config.Load(), loaded)
+	for _, setting := range rejected {
+		slog.Warn("Rejected configuration change", "setting", setting.name, "reason", setting.reason)
+	}
+	reloader.minioClient.config.Store(merged)
+	for _, listener := range reloader.listeners {
+		listener(merged)
+	}
+	slog.Info("Reloaded configuration")
+	return nil
+}
+
+// Changed setting that was not applied
+type rejectedSetting struct {
+	name   string
+	reason string
+}
+
+// Copies the settings that can change at runtime from loaded into a copy of current.
+// Returns the other settings that differ, they are kept at their current value
+func mergeReloadable(current *Config, loaded *Config) (*Config, []rejectedSetting) {
+	merged := *current
+	merged.Minio.Chunking = loaded.Minio.Chunking
+	// Read by the static credential provider for every new session
+	merged.Minio.AccessKeyID = loaded.Minio.AccessKeyID
+	merged.Minio.SecretAccessKey = loaded.Minio.SecretAccessKey
+	merged.Server.BlockSize = loaded.Server.BlockSize
+	merged.Server.DownloadRoutines = loaded.Server.DownloadRoutines
+	merged.Server.DefaultChunkSize = loaded.Server.DefaultChunkSize
+	merged.Server.ShutdownTimeout = loaded.Server.ShutdownTimeout
+	merged.Scrubber.BytesPerSecond = loaded.Scrubber.BytesPerSecond
+	merged.Logging = loaded.Logging
+	merged.Retry = loaded.Retry
+
+	// Files written with the current key must stay readable
+	keepsKey := loaded.Minio.EncryptionKey == current.Minio.EncryptionKey || slices.Contains(loaded.Minio.PreviousEncryptionKeys, current.Minio.EncryptionKey)
+	if keepsKey {
+		merged.Minio.EncryptionKey = loaded.Minio.EncryptionKey
+		merged.Minio.PreviousEncryptionKeys = loaded.Minio.PreviousEncryptionKeys
+	}
+
+	var rejected []rejectedSetting
+	for _, name := range differences(&merged, loaded) {
+		reason := "can not change at runtime, restart to apply it"
+		if !keepsKey && (name == "minio.encryptionKey" || name == "minio.previousEncryptionKeys") {
+			reason = "the current encryption key must be kept in minio.previousEncryptionKeys"
+		}
+		rejected = append(rejected, rejectedSetting{name, reason})
+	}
+	return &merged, rejected
+}
+
+// Names of the settings that differ, e.g. "minio.endpoint"
+func differences(a *Config, b *Config) []string {
+	var names []string
+	sectionsA := reflect.ValueOf(a).Elem()
+	sectionsB := reflect.ValueOf(b).Elem()
+	for i := 0; i < sectionsA.NumField(); i++ {
+		section := strings.ToLower(sectionsA.Type().Field(i).Name)
+		for j := 0; j < sectionsA.Field(i).NumField(); j++ {
+			if reflect.DeepEqual(sectionsA.Field(i).Field(j).Interface(), sectionsB.Field(i).Field(j).Interface()) {
+				continue
+			}
+			field := sectionsA.Field(i).Type().Field(j).Name
+			names = append(names, section+"."+strings.ToLower(field[:1])+field[1:])
+		}
+	}
+	return names
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+const otherKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func reloadConfig(key string, previous string, endpoint string, level string) string {
+	return `
+[minio]
+endpoint="` + endpoint + `"
+bucketName="file-storage"
+encryptionKey="` + key + `"
+previousEncryptionKeys=[` + previous + `]
+chunking=true
+
+[server]
+downloadRoutines=2
+
+[logging]
+level="` + level + `"
+`
+}
+
+func TestReload(t *testing.T) {
+	path := writeConfig(t, "config.toml", reloadConfig(testKey, "", "localhost:9000", "info"))
+	current, err := LoadConfiguration(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	minioClient := &MinioClient{}
+	minioClient.config.Store(current)
+	reloader := InitReloader(minioClient, path)
+	var reloaded *Config
+	reloader.OnReload(func(config *Config) { reloaded = config })
+
+	// Swappable settings are applied, the endpoint is kept
+	os.WriteFile(path, []byte(reloadConfig(otherKey, `"`+testKey+`"`, "minio:9000", "debug")), 0o600)
+	if err := reloader.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if reloaded == nil || reloaded != minioClient.config.Load() {
+		t.Fatal("listener not called with the new configuration")
+	}
+	if reloaded.Logging.Level != "debug" || reloaded.Minio.EncryptionKey != otherKey {
+		t.Errorf("swappable settings not applied: %+v", reloaded)
+	}
+	if reloaded.Minio.Endpoint != "localhost:9000" {
+		t.Errorf("endpoint changed at runtime to %s", reloaded.Minio.Endpoint)
+	}
+
+	// Invalid configuration keeps everything
+	os.WriteFile(path, []byte(reloadConfig("short", "", "localhost:9000", "info")), 0o600)
+	if err := reloader.Reload(); err == nil {
+		t.Fatal("Reload() of invalid configuration should fail")
+	}
+	if minioClient.config.Load() != reloaded {
+		t.Error("invalid configuration was applied")
+	}
+}
+
+func TestMergeReloadable(t *testing.T) {
+	current, _ := LoadConfiguration(writeConfig(t, "current.toml", reloadConfig(testKey, "", "localhost:9000", "info")))
+
+	// Dropping the current key would make existing files unreadable
+	loaded, _ := LoadConfiguration(writeConfig(t, "loaded.toml", reloadConfig(otherKey, "", "minio:9000", "info")))
+	merged, rejected := mergeReloadable(current, loaded)
+	if merged.Minio.EncryptionKey != testKey {
+		t.Error("key rotation without keeping the current key was applied")
+	}
+	names := make([]string, 0, len(rejected))
+	for _, setting := range rejected {
+		names = append(names, setting.name)
+		if setting.name == "minio.encryptionKey" && !strings.Contains(setting.reason, "previousEncryptionKeys") {
+			t.Errorf("unexpected reason for the key: %s", setting.reason)
+		}
+	}
+	if strings.Join(names, ",") != "minio.endpoint,minio.encryptionKey" {
+		t.Errorf("rejected = %v", names)
+	}
+}
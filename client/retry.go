@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"taurus-minio/logging"
+	"taurus-minio/metrics"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Returned without calling minio while the circuit breaker is open
+var ErrCircuitOpen = errors.New("minio is unavailable, circuit breaker is open")
+
+// Opens after a number of consecutive failed attempts and fails operations fast until the timeout passes.
+// Afterwards operations are let through again, the first failure opens it again and the first success closes it
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func (breaker *circuitBreaker) allow(conf RetryConfiguration) error {
+	if conf.BreakerThreshold == 0 {
+		return nil
+	}
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	if time.Now().Before(breaker.openUntil) {
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// Counts the result of an attempt. Errors that are not caused by an unavailable minio do not count as failures
+func (breaker *circuitBreaker) record(conf RetryConfiguration, err error) {
+	if isCancelled(err) {
+		return
+	}
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	if err == nil || !isRetryable(err) {
+		if breaker.failures >= conf.BreakerThreshold && conf.BreakerThreshold > 0 {
+			slog.Info("Minio is available again, closing circuit breaker")
+		}
+		breaker.failures = 0
+		metrics.SetCircuitOpen(false)
+		return
+	}
+	breaker.failures++
+	if conf.BreakerThreshold > 0 && breaker.failures >= conf.BreakerThreshold {
+		if !time.Now().Before(breaker.openUntil) {
+			slog.Warn("Minio keeps failing, opening circuit breaker", "failures", breaker.failures, "timeout", conf.BreakerTimeout, "error", err)
+		}
+		breaker.openUntil = time.Now().Add(conf.BreakerTimeout)
+		metrics.SetCircuitOpen(true)
+	}
+}
+
+func isCancelled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// Whether the error is transient, e.g. minio being overloaded or restarting, so the operation may succeed later
+func isRetryable(err error) bool {
+	if err == nil || isCancelled(err) {
+		return false
+	}
+	response := minio.ToErrorResponse(err)
+	switch response.Code {
+	case "SlowDown", "SlowDownWrite", "SlowDownRead", "RequestTimeout", "InternalError", "ServiceUnavailable", "XMinioServerNotInitialized":
+		return true
+	}
+	switch response.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Delay before the given retry (1 for the first one): exponential backoff with full jitter
+func backoff(conf RetryConfiguration, retry int) time.Duration {
+	delay := conf.InitialBackoff
+	for i := 1; i < retry && delay < conf.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > conf.MaxBackoff {
+		delay = conf.MaxBackoff
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+// Runs the minio operation with the retry policy and the circuit breaker of the current configuration.
+// Only idempotent operations are retried, call is attempted once otherwise.
+// Minio retries requests inside call on its own, its global retry limit is left to the application
+func (minioClient *MinioClient) retry(ctx context.Context, operation string, idempotent bool, call func() error) error {
+	conf := minioClient.config.Load().Retry
+	attempts := conf.MaxAttempts
+	if !idempotent {
+		attempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		if err := minioClient.breaker.allow(conf); err != nil {
+			return err
+		}
+		err := call()
+		minioClient.breaker.record(conf, err)
+		if err == nil || attempt >= attempts || !isRetryable(err) {
+			return err
+		}
+		delay := backoff(conf, attempt)
+		metrics.CountMinioRetry(operation)
+		logging.FromContext(ctx).Warn("Retrying minio operation", "operation", operation, "attempt", attempt, "delay", delay, "error", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+func testRetryClient(retry RetryConfiguration) *MinioClient {
+	minioClient := &MinioClient{}
+	minioClient.config.Store(&Config{Retry: retry})
+	return minioClient
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"Slow down", minio.ErrorResponse{Code: "SlowDown", StatusCode: http.StatusServiceUnavailable}, true},
+		{"Bad gateway", minio.ErrorResponse{StatusCode: http.StatusBadGateway}, true},
+		{"Connection dropped", io.ErrUnexpectedEOF, true},
+		{"Not found", minio.ErrorResponse{Code: "NoSuchKey", StatusCode: http.StatusNotFound}, false},
+		{"Access denied", minio.ErrorResponse{Code: "AccessDenied", StatusCode: http.StatusForbidden}, false},
+		{"Cancelled", context.Canceled, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Errorf("isRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	conf := RetryConfiguration{InitialBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	for retry := 1; retry <= 10; retry++ {
+		limit := conf.InitialBackoff << (retry - 1)
+		if limit > conf.MaxBackoff {
+			limit = conf.MaxBackoff
+		}
+		for i := 0; i < 100; i++ {
+			if delay := backoff(conf, retry); delay <= 0 || delay > limit {
+				t.Fatalf("backoff(%d) = %v, want between 0 and %v", retry, delay, limit)
+			}
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	minioClient := testRetryClient(RetryConfiguration{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+	unavailable := minio.ErrorResponse{Code: "ServiceUnavailable", StatusCode: http.StatusServiceUnavailable}
+
+	calls := 0
+	err := minioClient.retry(context.Background(), "Test", true, func() error {
+		calls++
+		if calls < 3 {
+			return unavailable
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("retry() = %v after %d calls, want success after 3", err, calls)
+	}
+
+	calls = 0
+	err = minioClient.retry(context.Background(), "Test", false, func() error {
+		calls++
+		return unavailable
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("retry() of a non idempotent operation = %v after %d calls, want failure after 1", err, calls)
+	}
+
+	calls = 0
+	minioClient.retry(context.Background(), "Test", true, func() error {
+		calls++
+		return minio.ErrorResponse{Code: "NoSuchKey", StatusCode: http.StatusNotFound}
+	})
+	if calls != 1 {
+		t.Errorf("retry() of a permanent error made %d calls, want 1", calls)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	minioClient := testRetryClient(RetryConfiguration{MaxAttempts: 1, BreakerThreshold: 2, BreakerTimeout: 50 * time.Millisecond})
+	unavailable := minio.ErrorResponse{Code: "ServiceUnavailable", StatusCode: http.StatusServiceUnavailable}
+	calls := 0
+	fail := func() error {
+		calls++
+		return unavailable
+	}
+
+	minioClient.retry(context.Background(), "Test", true, fail)
+	minioClient.retry(context.Background(), "Test", true, fail)
+	if err := minioClient.retry(context.Background(), "Test", true, fail); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("retry() with open breaker = %v, want %v", err, ErrCircuitOpen)
+	}
+	if calls != 2 {
+		t.Errorf("open breaker called minio, %d calls, want 2", calls)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := minioClient.retry(context.Background(), "Test", true, func() error { return nil }); err != nil {
+		t.Fatalf("retry() after breaker timeout = %v, want success", err)
+	}
+	if err := minioClient.retry(context.Background(), "Test", true, fail); errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("breaker opened again after a single failure following a success")
+	}
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Builds the HTTP transport to minio with the TLS settings of the configuration
+func newTransport(conf *MinioConfiguration) (*http.Transport, error) {
+	transport, err := minio.DefaultTransport(conf.UseSSL)
+	if err != nil {
+		return nil, err
+	}
+	if !conf.UseSSL {
+		return transport, nil
+	}
+
+	tlsConfig := transport.TLSClientConfig
+	if conf.CABundle != "" {
+		// Keep the system CAs, e.g. for a proxy in front of minio with a public certificate
+		pool := tlsConfig.RootCAs
+		if pool == nil {
+			if pool, err = x509.SystemCertPool(); err != nil {
+				pool = x509.NewCertPool()
+			}
+		}
+		data, err := os.ReadFile(conf.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("minio.caBundle: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("minio.caBundle: no certificates found in %s", conf.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if conf.ClientCertificate != "" {
+		certificate, err := tls.LoadX509KeyPair(conf.ClientCertificate, conf.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("minio.clientCertificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	tlsConfig.ServerName = conf.ServerName
+	if conf.InsecureSkipVerify {
+		slog.Warn("!!! Certificate verification of minio is DISABLED (minio.insecureSkipVerify), never use this in production !!!", "endpoint", conf.Endpoint)
+		tlsConfig.InsecureSkipVerify = true
+	}
+	return transport, nil
+}
@@ -0,0 +1,48 @@
+package client
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewTransport(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	bundle := writeConfig(t, "ca.pem", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})))
+	endpoint := strings.TrimPrefix(server.URL, "https://")
+
+	tests := []struct {
+		name    string
+		conf    MinioConfiguration
+		wantErr bool
+	}{
+		{"System CAs only", MinioConfiguration{Endpoint: endpoint, UseSSL: true}, true},
+		{"CA bundle", MinioConfiguration{Endpoint: endpoint, UseSSL: true, CABundle: bundle}, false},
+		// The test certificate is issued for example.com
+		{"CA bundle and server name", MinioConfiguration{Endpoint: endpoint, UseSSL: true, CABundle: bundle, ServerName: "example.com"}, false},
+		{"Wrong server name", MinioConfiguration{Endpoint: endpoint, UseSSL: true, CABundle: bundle, ServerName: "minio.internal"}, true},
+		{"Insecure", MinioConfiguration{Endpoint: endpoint, UseSSL: true, InsecureSkipVerify: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transport, err := newTransport(&tt.conf)
+			if err != nil {
+				t.Fatalf("newTransport() error = %v", err)
+			}
+			response, err := (&http.Client{Transport: transport}).Get(server.URL)
+			if err == nil {
+				response.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GET error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := newTransport(&MinioConfiguration{UseSSL: true, CABundle: writeConfig(t, "empty.pem", "")}); err == nil {
+		t.Error("newTransport() with an empty CA bundle succeeded")
+	}
+}
@@ -0,0 +1,210 @@
+// Command taurus reads and writes the stored object format without the server,
+// e.g. to recover files while the gateway is down
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"taurus-minio/client"
+	"taurus-minio/encryption"
+	"taurus-minio/files"
+	"taurus-minio/logging"
+)
+
+const USAGE = `Usage: taurus <command> [flags] <arguments>
+
+Commands:
+  encrypt [-block-size n] <file> <object>
+        encrypt a local file into a stored object
+  decrypt [-block-size n] <object>... <file>
+        decrypt objects downloaded from minio, the chunks of a file are given in order
+  decrypt -manifest <manifest> [-dir bucket] <file>
+        decrypt the objects listed in a manifest from a local copy of the bucket
+  get [-version id] <name> <file>
+        fetch and decrypt a file directly from minio
+
+Every command takes -config, the configuration of the server with the encryption keys.
+Use - as file for stdin or stdout.
+`
+
+type command func(ctx context.Context, args []string) error
+
+func main() {
+	// Stdout may carry the decrypted file, so logs go to stderr
+	slog.SetDefault(slog.New(logging.NewHandler(os.Stderr)))
+	logging.SetLevel("warn")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, USAGE) }
+	flag.Parse()
+
+	commands := map[string]command{
+		"encrypt": encryptCommand,
+		"decrypt": decryptCommand,
+		"get":     getCommand,
+	}
+	run, ok := commands[flag.Arg(0)]
+	if !ok {
+		flag.Usage()
+		os.Exit(2)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := run(ctx, flag.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "taurus "+flag.Arg(0)+":", err)
+		os.Exit(1)
+	}
+}
+
+// Flags shared by every command
+func newFlagSet(name string) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, USAGE) }
+	configPath := flags.String("config", os.Getenv("TAURUS_CONFIG"), "configuration file (.toml, .json or .yaml), defaults to "+client.DEFAULT_CONFIG_FILE)
+	return flags, configPath
+}
+
+// Configuration of the server and its keys, the active one first
+func loadKeyring(configPath string) (*client.Config, *encryption.Keyring, error) {
+	config, err := client.LoadConfiguration(configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys, err := config.Minio.EncryptionKeys()
+	if err != nil {
+		return nil, nil, err
+	}
+	return config, encryption.InitKeyring(keys[0], keys[1:]...), nil
+}
+
+func encryptCommand(ctx context.Context, args []string) error {
+	flags, configPath := newFlagSet("encrypt")
+	blockSize := flags.Uint64("block-size", 0, "plaintext bytes per encrypted block, defaults to server.blockSize")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		return errors.New("expected <file> <object>")
+	}
+	config, keyring, err := loadKeyring(*configPath)
+	if err != nil {
+		return err
+	}
+	if *blockSize == 0 {
+		*blockSize = uint64(config.Server.BlockSize)
+	}
+
+	in, err := openInput(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeOutput(flags.Arg(1), func(out io.Writer) error {
+		// Needed to decrypt the object later, the server records them in the manifest
+		fmt.Fprintf(os.Stderr, "keyId=%s blockSize=%d\n", keyring.Active().KeyID(), *blockSize)
+		return files.EncryptObject(ctx, in, out, keyring.Active(), *blockSize)
+	})
+}
+
+func decryptCommand(ctx context.Context, args []string) error {
+	flags, configPath := newFlagSet("decrypt")
+	blockSize := flags.Uint64("block-size", files.BUFFER_SIZE, "plaintext bytes per encrypted block, see blockSize of the manifest")
+	manifestPath := flags.String("manifest", "", "manifest of the file, from .taurus/manifests or .taurus/versions of the bucket")
+	dir := flags.String("dir", ".", "local copy of the bucket, e.g. made with mc mirror, the manifest refers to its objects")
+	flags.Parse(args)
+	_, keyring, err := loadKeyring(*configPath)
+	if err != nil {
+		return err
+	}
+
+	var manifest *files.Manifest
+	root := ""
+	if *manifestPath != "" {
+		if flags.NArg() != 1 {
+			return errors.New("expected <file> with -manifest")
+		}
+		if manifest, err = readManifest(*manifestPath); err != nil {
+			return err
+		}
+		root = *dir
+	} else {
+		if flags.NArg() < 2 {
+			return errors.New("expected <object>... <file>")
+		}
+		// Key and block size are not known, every key is tried
+		manifest = &files.Manifest{BlockSize: *blockSize}
+		for _, object := range flags.Args()[:flags.NArg()-1] {
+			manifest.Objects = append(manifest.Objects, files.ManifestObject{Name: object})
+		}
+	}
+	open := func(ctx context.Context, name string) (io.ReadCloser, error) {
+		if root == "" {
+			return openInput(name)
+		}
+		return os.Open(filepath.Join(root, filepath.FromSlash(name)))
+	}
+	return writeOutput(flags.Arg(flags.NArg()-1), func(out io.Writer) error {
+		return files.DecryptManifest(ctx, manifest, keyring, open, out)
+	})
+}
+
+func getCommand(ctx context.Context, args []string) error {
+	flags, configPath := newFlagSet("get")
+	version := flags.String("version", "", "version of the file, defaults to the current one")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		return errors.New("expected <name> <file>")
+	}
+	config, keyring, err := loadKeyring(*configPath)
+	if err != nil {
+		return err
+	}
+	fh := files.InitFileHandler(client.CreateMinioClient(config), keyring)
+	return writeOutput(flags.Arg(1), func(out io.Writer) error {
+		return fh.ReadFile(ctx, flags.Arg(0), *version, out)
+	})
+}
+
+func readManifest(path string) (*files.Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest files.Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &manifest, nil
+}
+
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+// Writes to the file, which is removed again if write fails, so no partial file is left behind
+func writeOutput(path string, write func(io.Writer) error) error {
+	if path == "-" {
+		return write(os.Stdout)
+	}
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	err = write(out)
+	if errClose := out.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
@@ -4,12 +4,19 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"io"
 )
 
+// Returned when a block cannot even hold the IV and GCM tag
+var ErrShortBlock = errors.New("encrypted block is shorter than IV and GCM tag")
+
 type Cryptographer struct {
-	gcm cipher.AEAD
+	gcm   cipher.AEAD
+	keyId string
 }
 
 // Constructor for cryptography system
@@ -24,11 +31,19 @@ func InitEncrypter(key []byte) *Cryptographer {
 		panic(err.Error())
 	}
 
+	// The id must not reveal the key, so it is derived from its hash
+	sum := sha256.Sum256(key)
 	return &Cryptographer{
-		gcm: aesgcm,
+		gcm:   aesgcm,
+		keyId: hex.EncodeToString(sum[:8]),
 	}
 }
 
+// Identifies the key without revealing it. Stored with the data to find the key for decryption
+func (cryptographer *Cryptographer) KeyID() string {
+	return cryptographer.keyId
+}
+
 // Helper function to generate random number of a given length
 func (cryptographer *Cryptographer) GenerateIV(length int) []byte {
 	nonce := make([]byte, length)
@@ -79,6 +94,20 @@ func (cryptographer Cryptographer) Encrypt(filePart []byte, blockId uint64, file
 // Takes cypherText `filePart` and decrypts it
 // `blockId` and `fileId` are used again for preserving integrity when decrypting
 func (cryptographer Cryptographer) Decrypt(filePart []byte, fileId []byte, blockId uint64) []byte {
+	decryptedBytes, err := cryptographer.TryDecrypt(filePart, fileId, blockId)
+	if err != nil {
+		panic(err.Error())
+	}
+	return decryptedBytes
+}
+
+// Same as Decrypt, but returns an error instead of panicking
+// when the block is too short or the GCM tag does not match.
+// Used for integrity checks where corrupt data is expected
+func (cryptographer Cryptographer) TryDecrypt(filePart []byte, fileId []byte, blockId uint64) ([]byte, error) {
+	if len(filePart) < 12+cryptographer.gcm.Overhead() {
+		return nil, ErrShortBlock
+	}
 	// Convert to bytes and create additional data for GCM
 	block := cryptographer.idToBytes(blockId)
 	additional_data := append(block, fileId...)
@@ -89,10 +118,5 @@ func (cryptographer Cryptographer) Decrypt(filePart []byte, fileId []byte, block
 	// Actual cyphertext
 	dataBytes := filePart[12:]
 
-	decryptedBytes, err := cryptographer.gcm.Open(nil, iv, dataBytes, additional_data)
-
-	if err != nil {
-		panic(err.Error())
-	}
-	return decryptedBytes
+	return cryptographer.gcm.Open(nil, iv, dataBytes, additional_data)
 }
@@ -71,3 +71,53 @@ func TestChunkingEncryptDecrypt(t *testing.T) {
 		})
 	}
 }
+
+func TestTryDecryptDetectsTampering(t *testing.T) {
+	key, _ := hex.DecodeString("6368616e676520746869732070617373776f726420746f206120736563726574")
+	cryptographer := InitEncrypter(key)
+	fileId := cryptographer.GenerateIV(16)
+	result := cryptographer.Encrypt([]byte("Some random content"), 1, fileId)
+
+	if _, err := cryptographer.TryDecrypt(result, fileId, 1); err != nil {
+		t.Errorf("CryptoService.TryDecrypt() unexpected error = %v", err)
+	}
+	if _, err := cryptographer.TryDecrypt(result, fileId, 2); err == nil {
+		t.Errorf("CryptoService.TryDecrypt() wrong block id must fail")
+	}
+	result[len(result)-1] ^= 0xff
+	if _, err := cryptographer.TryDecrypt(result, fileId, 1); err == nil {
+		t.Errorf("CryptoService.TryDecrypt() tampered tag must fail")
+	}
+	if _, err := cryptographer.TryDecrypt(result[:10], fileId, 1); err != ErrShortBlock {
+		t.Errorf("CryptoService.TryDecrypt() error = %v, want = %v", err, ErrShortBlock)
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	active, _ := hex.DecodeString("6368616e676520746869732070617373776f726420746f206120736563726574")
+	previous, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	keyring := InitKeyring(active, previous, active)
+
+	if len(keyring.All()) != 2 {
+		t.Fatalf("expected duplicate key to be skipped, got %d keys", len(keyring.All()))
+	}
+	if keyring.Active().KeyID() == keyring.All()[1].KeyID() {
+		t.Fatal("different keys must have different ids")
+	}
+	if keyring.Active().KeyID() != InitEncrypter(active).KeyID() {
+		t.Error("key id must only depend on the key")
+	}
+
+	fileId := keyring.Active().GenerateIV(16)
+	encrypted := InitEncrypter(previous).Encrypt([]byte("Text"), 0, fileId)
+	old := keyring.Get(InitEncrypter(previous).KeyID())
+	if old == nil {
+		t.Fatal("previous key not found by its id")
+	}
+	if decrypted, err := old.TryDecrypt(encrypted, fileId, 0); err != nil || string(decrypted) != "Text" {
+		t.Errorf("TryDecrypt() with previous key = %q, %v", decrypted, err)
+	}
+	if keyring.Get("unknown") != nil {
+		t.Error("unknown key id must return nil")
+	}
+}
@@ -0,0 +1,37 @@
+package encryption
+
+// Keys of the application. New data is encrypted with the active key,
+// previous keys are kept to decrypt data written before a key rotation
+type Keyring struct {
+	keys []*Cryptographer
+	byId map[string]*Cryptographer
+}
+
+// Creates keyring with the active key and the keys of earlier rotations
+func InitKeyring(active []byte, previous ...[]byte) *Keyring {
+	keyring := &Keyring{byId: make(map[string]*Cryptographer)}
+	for _, key := range append([][]byte{active}, previous...) {
+		cryptographer := InitEncrypter(key)
+		if _, ok := keyring.byId[cryptographer.KeyID()]; ok {
+			continue
+		}
+		keyring.keys = append(keyring.keys, cryptographer)
+		keyring.byId[cryptographer.KeyID()] = cryptographer
+	}
+	return keyring
+}
+
+// Key used to encrypt new data
+func (keyring *Keyring) Active() *Cryptographer {
+	return keyring.keys[0]
+}
+
+// Key with the given id, nil if it is not part of the keyring
+func (keyring *Keyring) Get(keyId string) *Cryptographer {
+	return keyring.byId[keyId]
+}
+
+// Every key, the active one first
+func (keyring *Keyring) All() []*Cryptographer {
+	return keyring.keys
+}
@@ -0,0 +1,252 @@
+package files
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"taurus-minio/logging"
+	"taurus-minio/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Formats of archive downloads, selected with the `format` query parameter
+const (
+	ARCHIVE_ZIP    = "zip"
+	ARCHIVE_TAR_GZ = "tar.gz"
+)
+
+// Streams the current version of every file whose name starts with the `prefix` query parameter as one archive
+func (fh *FileHandler) GetArchiveHandler(c *gin.Context) {
+	defer func() { metrics.CountDownload(c.Writer.Status()) }()
+	ctx := c.Request.Context()
+	manifests, err := fh.readManifestsUnder(ctx, getManifestName(c.Query("prefix")))
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to list files", "prefix", c.Query("prefix"), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Could not list files",
+		})
+		return
+	}
+	sort.Slice(manifests, func(i, j int) bool {
+		return manifests[i].Name < manifests[j].Name
+	})
+	fh.respondArchive(c, manifests)
+}
+
+// Streams the current version of the files listed in the JSON body {"names": [...]} as one archive
+func (fh *FileHandler) PostArchiveHandler(c *gin.Context) {
+	defer func() { metrics.CountDownload(c.Writer.Status()) }()
+	ctx := c.Request.Context()
+	var request struct {
+		Names []string `json:"names"`
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(&request); err != nil || len(request.Names) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": `Body must be {"names": [...]} with at least one file name`,
+		})
+		return
+	}
+
+	manifests := make([]*Manifest, 0, len(request.Names))
+	seen := make(map[string]bool)
+	for _, name := range request.Names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		// Files uploaded before manifests were introduced are found by their name
+		manifest, err := fh.findManifest(ctx, name, "")
+		if errors.Is(err, ErrFileNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Could not find file " + name,
+			})
+			return
+		} else if err != nil {
+			logging.FromContext(ctx).Error("Failed to read manifest", "file", name, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Could not read file manifest",
+			})
+			return
+		}
+		manifests = append(manifests, manifest)
+	}
+	fh.respondArchive(c, manifests)
+}
+
+// Writes the files as archive in the requested format, decrypting one file after another.
+// Everything that can fail before the first byte is checked up front.
+// Later failures end the response before the end of the archive, so clients see a broken archive
+func (fh *FileHandler) respondArchive(c *gin.Context, manifests []*Manifest) {
+	ctx := c.Request.Context()
+	logger := logging.FromContext(ctx)
+	format := c.DefaultQuery("format", ARCHIVE_ZIP)
+	if format != ARCHIVE_ZIP && format != ARCHIVE_TAR_GZ {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "format must be zip or tar.gz",
+		})
+		return
+	}
+
+	// Tar headers need the size of every file before its content
+	infos := make([]*FileInfo, len(manifests))
+	for i, manifest := range manifests {
+		keys := fh.keysFor(manifest.KeyID)
+		if len(keys) == 0 {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Encryption key of file " + manifest.Name + " is not configured",
+			})
+			return
+		}
+		info, err := manifest.fileInfo(keys)
+		if err == nil && manifest.Info == nil && format == ARCHIVE_TAR_GZ {
+			// Files uploaded before the size was recorded
+			info.Size, err = fh.plaintextSize(ctx, manifest)
+		}
+		if err != nil {
+			logger.Error("Failed to read file info", "file", manifest.Name, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Could not read file " + manifest.Name,
+			})
+			return
+		}
+		if info.ModTime.IsZero() {
+			info.ModTime = manifest.CreatedAt
+		}
+		infos[i] = info
+	}
+
+	contentType := "application/zip"
+	if format == ARCHIVE_TAR_GZ {
+		contentType = "application/gzip"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="files.%s"`, format))
+	c.Status(http.StatusOK)
+
+	archive := newArchiveWriter(format, c.Writer)
+	for i, manifest := range manifests {
+		if err := fh.writeArchiveEntry(ctx, archive, manifest, infos[i]); err != nil {
+			// Not closing the archive leaves it without its end, which clients report as damaged
+			logger.Error("Failed to add file to archive", "file", manifest.Name, "error", err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		logger.Error("Failed to finish archive", "error", err)
+	}
+}
+
+// Decrypts the file into the next entry of the archive
+func (fh *FileHandler) writeArchiveEntry(ctx context.Context, archive archiveWriter, manifest *Manifest, info *FileInfo) error {
+	entry, err := archive.create(manifest.Name, info)
+	if err != nil {
+		return err
+	}
+	reader, err := fh.openFile(ctx, manifest, fh.keysFor(manifest.KeyID))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	_, err = io.Copy(entry, reader)
+	return err
+}
+
+// Plaintext bytes of the file, computed from the stored sizes
+func (fh *FileHandler) plaintextSize(ctx context.Context, manifest *Manifest) (int64, error) {
+	sizes, err := fh.objectSizes(ctx, manifest)
+	if err != nil {
+		return 0, err
+	}
+	total := int64(0)
+	for _, size := range sizes {
+		total += size
+	}
+	return total, nil
+}
+
+// Plaintext bytes of every object of the file.
+// Every block but the last is full, so each object holds the file id and full blocks of blockSize
+func (fh *FileHandler) objectSizes(ctx context.Context, manifest *Manifest) ([]int64, error) {
+	block := int64(manifest.blockSize() + getEncryptionOverhead())
+	sizes := make([]int64, len(manifest.Objects))
+	for i, object := range manifest.Objects {
+		stored := object.Size
+		if stored == 0 {
+			// Made up manifests of files uploaded before manifests do not record sizes
+			info, err := fh.minioClient.StatObject(ctx, object.Name)
+			if err != nil {
+				return nil, err
+			}
+			stored = info.Size
+		}
+		ciphertext := stored - 16
+		if ciphertext <= 0 {
+			continue
+		}
+		blocks := (ciphertext + block - 1) / block
+		sizes[i] = ciphertext - blocks*int64(getEncryptionOverhead())
+	}
+	return sizes, nil
+}
+
+// Archive written entry by entry to a stream
+type archiveWriter interface {
+	// Starts the entry of the file, the size is only used by formats that need it up front
+	create(name string, info *FileInfo) (io.Writer, error)
+	Close() error
+}
+
+func newArchiveWriter(format string, w io.Writer) archiveWriter {
+	if format == ARCHIVE_TAR_GZ {
+		compressed := gzip.NewWriter(w)
+		return &tarGzWriter{compressed: compressed, archive: tar.NewWriter(compressed)}
+	}
+	return &zipWriter{archive: zip.NewWriter(w)}
+}
+
+type zipWriter struct {
+	archive *zip.Writer
+}
+
+func (writer *zipWriter) create(name string, info *FileInfo) (io.Writer, error) {
+	return writer.archive.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: info.ModTime,
+	})
+}
+
+func (writer *zipWriter) Close() error {
+	return writer.archive.Close()
+}
+
+type tarGzWriter struct {
+	compressed *gzip.Writer
+	archive    *tar.Writer
+}
+
+func (writer *tarGzWriter) create(name string, info *FileInfo) (io.Writer, error) {
+	err := writer.archive.WriteHeader(&tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     info.Size,
+		ModTime:  info.ModTime,
+	})
+	return writer.archive, err
+}
+
+func (writer *tarGzWriter) Close() error {
+	if err := writer.archive.Close(); err != nil {
+		return err
+	}
+	return writer.compressed.Close()
+}
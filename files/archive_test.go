@@ -0,0 +1,75 @@
+package files
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestPlaintextSize(t *testing.T) {
+	fh := testFileHandler()
+	for _, size := range []int{0, 1, 1023, 1024, 1025, 3000} {
+		stored := encryptForTest(fh, strings.Repeat("a", size), 1024)
+		manifest := &Manifest{BlockSize: 1024, Objects: []ManifestObject{{Name: "data", Size: int64(len(stored))}}}
+		got, err := fh.plaintextSize(context.Background(), manifest)
+		if err != nil || got != int64(size) {
+			t.Errorf("plaintextSize() of %d bytes stored as %d = %d, %v", size, len(stored), got, err)
+		}
+	}
+}
+
+func TestArchiveWriter(t *testing.T) {
+	files := []*Manifest{{Name: "report.pdf"}, {Name: "docs/notes.txt"}}
+	content := []string{"first", "second"}
+	for _, format := range []string{ARCHIVE_ZIP, ARCHIVE_TAR_GZ} {
+		var buf bytes.Buffer
+		archive := newArchiveWriter(format, &buf)
+		for i, manifest := range files {
+			entry, err := archive.create(manifest.Name, &FileInfo{Size: int64(len(content[i]))})
+			if err != nil {
+				t.Fatalf("%s: create() error = %v", format, err)
+			}
+			io.WriteString(entry, content[i])
+		}
+		if err := archive.Close(); err != nil {
+			t.Fatalf("%s: Close() error = %v", format, err)
+		}
+
+		read := make(map[string]string)
+		if format == ARCHIVE_ZIP {
+			reader, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatalf("zip: %v", err)
+			}
+			for _, file := range reader.File {
+				r, _ := file.Open()
+				data, _ := io.ReadAll(r)
+				read[file.Name] = string(data)
+			}
+		} else {
+			uncompressed, err := gzip.NewReader(&buf)
+			if err != nil {
+				t.Fatalf("tar.gz: %v", err)
+			}
+			reader := tar.NewReader(uncompressed)
+			for {
+				header, err := reader.Next()
+				if err != nil {
+					break
+				}
+				data, _ := io.ReadAll(reader)
+				read[header.Name] = string(data)
+			}
+		}
+		for i, manifest := range files {
+			if read[manifest.Name] != content[i] {
+				t.Errorf("%s: %s = %q, want %q", format, manifest.Name, read[manifest.Name], content[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package files
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Archive entries with absolute names, names leaving the archive or naming internal objects
+var errInvalidEntryName = errors.New("Invalid file name in archive")
+
+// Outcome of a single file of an upload request. Files of a batch fail on their own
+type fileResult struct {
+	Name      string   `json:"name"`
+	Status    string   `json:"status"`
+	Message   string   `json:"message,omitempty"`
+	VersionID string   `json:"versionId,omitempty"`
+	Size      int64    `json:"size"`
+	SHA256    string   `json:"sha256,omitempty"`
+	Chunked   bool     `json:"chunked"`
+	Chunks    int      `json:"chunks,omitempty"`
+	ETag      string   `json:"ETag,omitempty"`
+	Tags      []string `json:"Tags,omitempty"`
+
+	// Stored but not committed yet, nil once committed or failed
+	upload   *pendingUpload
+	manifest *Manifest
+	checksum *checksumReader
+	err      error
+}
+
+// Hashes the plaintext while it is read
+type checksumReader struct {
+	reader io.Reader
+	hash   hash.Hash
+	size   int64
+}
+
+func (reader *checksumReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+	reader.hash.Write(p[:n])
+	reader.size += int64(n)
+	return n, err
+}
+
+// Encrypts and stores one file of the request without committing it.
+// Failures are recorded in the result, errors are caused by the request
+func (fh *FileHandler) storePart(ctx context.Context, name string, contentType string, modTime time.Time, file io.Reader, chunkSize string, metadata map[string]string) (*fileResult, error) {
+	options, err := fh.parseUploadOptions(chunkSize, contentType, metadata, "", "", "")
+	if err != nil {
+		return nil, err
+	}
+	options.modTime = modTime
+	result := &fileResult{Name: name, checksum: &checksumReader{reader: file, hash: sha256.New()}}
+	result.upload, result.err = fh.storeObjects(ctx, name, result.checksum, options)
+	return result, nil
+}
+
+// Stores every regular file of the archive as its own file.
+// A broken archive is recorded as a failed result named after the archive, the files before it are kept
+func (fh *FileHandler) storeArchive(ctx context.Context, name string, archive io.Reader, chunkSize string, metadata map[string]string) ([]*fileResult, error) {
+	var results []*fileResult
+	err := readArchive(archive, func(entry string, modTime time.Time, file io.Reader) error {
+		if !validEntryName(entry) {
+			results = append(results, &fileResult{Name: entry, err: errInvalidEntryName})
+			return nil
+		}
+		entry = path.Clean(entry)
+		// The content type is detected from the content and the name of the entry
+		result, err := fh.storePart(ctx, entry, "", modTime, file, chunkSize, metadata)
+		if err != nil {
+			return err
+		}
+		results = append(results, result)
+		return nil
+	})
+	var invalid *archiveError
+	if errors.As(err, &invalid) {
+		return append(results, &fileResult{Name: name, err: err}), nil
+	}
+	return results, err
+}
+
+// Archive that cannot be read, fails only the files that were not read yet
+type archiveError struct {
+	err error
+}
+
+func (err *archiveError) Error() string {
+	return "reading archive: " + err.err.Error()
+}
+
+func (err *archiveError) Unwrap() error {
+	return err.err
+}
+
+// Calls store with the name, modification time and content of every regular file of a tar, gzip compressed tar or zip archive.
+// The format is detected from the content. Zip archives are spooled to an encrypted temporary file,
+// as their directory is at the end. Errors of store are returned as they are, broken archives as *archiveError
+func readArchive(archive io.Reader, store func(name string, modTime time.Time, file io.Reader) error) error {
+	buffered := bufio.NewReader(archive)
+	magic, _ := buffered.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		uncompressed, err := gzip.NewReader(buffered)
+		if err != nil {
+			return &archiveError{err}
+		}
+		defer uncompressed.Close()
+		return readTar(uncompressed, store)
+	case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+		return readZip(buffered, store)
+	}
+	return readTar(buffered, store)
+}
+
+func readTar(archive io.Reader, store func(name string, modTime time.Time, file io.Reader) error) error {
+	reader := tar.NewReader(archive)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &archiveError{err}
+		}
+		if header.Typeflag != tar.TypeReg {
+			// Directories, links and devices have no content of their own
+			continue
+		}
+		if err := store(header.Name, header.ModTime, reader); err != nil {
+			return err
+		}
+	}
+}
+
+func readZip(archive io.Reader, store func(name string, modTime time.Time, file io.Reader) error) error {
+	spool, err := newEncryptedSpool()
+	if err != nil {
+		return err
+	}
+	defer spool.Close()
+	size, err := io.Copy(spool, archive)
+	if err != nil {
+		return err
+	}
+
+	reader, err := zip.NewReader(spool, size)
+	if err != nil {
+		return &archiveError{err}
+	}
+	for _, entry := range reader.File {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		file, err := entry.Open()
+		if err != nil {
+			return &archiveError{err}
+		}
+		err = store(entry.Name, entry.Modified, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Temporary file holding a zip archive, encrypted in counter mode with a key that only lives in memory.
+// Files left behind, e.g. after a crash, cannot be read by anyone
+type encryptedSpool struct {
+	file   *os.File
+	block  cipher.Block
+	prefix []byte
+	// Keystream of the next Write, the file is written from start to end
+	writer cipher.Stream
+}
+
+func newEncryptedSpool() (*encryptedSpool, error) {
+	key := make([]byte, 32)
+	// Random first half of the counter, the second half counts the blocks
+	prefix := make([]byte, aes.BlockSize/2)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.CreateTemp("", "taurus-archive-*")
+	if err != nil {
+		return nil, err
+	}
+	spool := &encryptedSpool{file: file, block: block, prefix: prefix}
+	spool.writer = spool.streamAt(0)
+	return spool, nil
+}
+
+// Keystream starting at the byte offset of the file
+func (spool *encryptedSpool) streamAt(offset int64) cipher.Stream {
+	counter := make([]byte, aes.BlockSize)
+	copy(counter, spool.prefix)
+	binary.BigEndian.PutUint64(counter[len(spool.prefix):], uint64(offset/aes.BlockSize))
+	stream := cipher.NewCTR(spool.block, counter)
+	skip := make([]byte, offset%aes.BlockSize)
+	stream.XORKeyStream(skip, skip)
+	return stream
+}
+
+func (spool *encryptedSpool) Write(p []byte) (int, error) {
+	encrypted := make([]byte, len(p))
+	spool.writer.XORKeyStream(encrypted, p)
+	return spool.file.Write(encrypted)
+}
+
+func (spool *encryptedSpool) ReadAt(p []byte, offset int64) (int, error) {
+	n, err := spool.file.ReadAt(p, offset)
+	spool.streamAt(offset).XORKeyStream(p[:n], p[:n])
+	return n, err
+}
+
+// Closes and removes the file
+func (spool *encryptedSpool) Close() error {
+	spool.file.Close()
+	return os.Remove(spool.file.Name())
+}
+
+// Names of archive entries become file names, they must stay relative and outside of the internal objects
+func validEntryName(name string) bool {
+	cleaned := path.Clean(name)
+	return cleaned == strings.TrimPrefix(name, "./") && cleaned != "." && !path.IsAbs(cleaned) &&
+		cleaned != ".." && !strings.HasPrefix(cleaned, "../") && !strings.HasPrefix(cleaned, INTERNAL_PREFIX)
+}
+
+// Locks and commits the stored file
+func (fh *FileHandler) finishUpload(ctx context.Context, result *fileResult, options *uploadOptions) {
+	if result.err != nil {
+		return
+	}
+	if err := result.upload.lock(ctx, options.lock); err != nil {
+		result.err = err
+		return
+	}
+	if err := result.upload.commit(ctx); err != nil {
+		result.err = err
+		return
+	}
+	result.manifest = &result.upload.manifest
+	result.upload = nil
+}
+
+// Fills in the answer of a finished file
+func (result *fileResult) report() {
+	if result.err != nil || result.manifest == nil {
+		result.Status = "failed"
+		result.Message = "Error uploading file"
+		var invalid *archiveError
+		if errors.As(result.err, &invalid) || errors.Is(result.err, errInvalidEntryName) {
+			result.Message = result.err.Error()
+		}
+		return
+	}
+	manifest := result.manifest
+	result.Status = "success"
+	result.VersionID = manifest.VersionID
+	result.Size = result.checksum.size
+	result.SHA256 = hex.EncodeToString(result.checksum.hash.Sum(nil))
+	result.Chunked = manifest.Chunked
+	if !manifest.Chunked {
+		result.ETag = manifest.Objects[0].ETag
+		return
+	}
+	result.Chunks = len(manifest.Objects)
+	for _, object := range manifest.Objects {
+		result.Tags = append(result.Tags, object.ETag)
+	}
+}
@@ -0,0 +1,173 @@
+package files
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var archiveFiles = map[string]string{"report.pdf": "first", "docs/notes.txt": "second"}
+
+func tarArchive(t *testing.T, compress bool) []byte {
+	var buf bytes.Buffer
+	var w io.Writer = &buf
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(&buf)
+		w = gz
+	}
+	archive := tar.NewWriter(w)
+	archive.WriteHeader(&tar.Header{Name: "docs/", Typeflag: tar.TypeDir, Mode: 0755})
+	for name, content := range archiveFiles {
+		archive.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))})
+		archive.Write([]byte(content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if gz != nil {
+		gz.Close()
+	}
+	return buf.Bytes()
+}
+
+func zipArchive(t *testing.T) []byte {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	archive.Create("docs/")
+	for name, content := range archiveFiles {
+		w, _ := archive.Create(name)
+		w.Write([]byte(content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadArchive(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive []byte
+	}{
+		{"tar", tarArchive(t, false)},
+		{"tar.gz", tarArchive(t, true)},
+		{"zip", zipArchive(t)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			read := make(map[string]string)
+			err := readArchive(bytes.NewReader(tt.archive), func(name string, modTime time.Time, file io.Reader) error {
+				content, err := io.ReadAll(file)
+				read[name] = string(content)
+				return err
+			})
+			if err != nil {
+				t.Fatalf("readArchive() error = %v", err)
+			}
+			if !maps.Equal(read, archiveFiles) {
+				t.Errorf("readArchive() read %v, want %v", read, archiveFiles)
+			}
+		})
+	}
+
+	// Files before the damage are read, the archive fails on its own
+	broken := zipArchive(t)
+	err := readArchive(bytes.NewReader(broken[:len(broken)-10]), func(string, time.Time, io.Reader) error { return nil })
+	var invalid *archiveError
+	if !errors.As(err, &invalid) {
+		t.Errorf("readArchive() of a truncated zip error = %v, want *archiveError", err)
+	}
+}
+
+func TestEncryptedSpool(t *testing.T) {
+	content := bytes.Repeat([]byte("plaintext of the archive "), 1000)
+	spool, err := newEncryptedSpool()
+	if err != nil {
+		t.Fatalf("newEncryptedSpool() error = %v", err)
+	}
+	name := spool.file.Name()
+	// Written in pieces not aligned to the cipher blocks
+	for i := 0; i < len(content); i += 1000 {
+		if _, err := spool.Write(content[i:min(i+1000, len(content))]); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if stored, _ := os.ReadFile(name); bytes.Contains(stored, []byte("plaintext")) {
+		t.Error("spool stores the archive in plaintext")
+	}
+	for _, offset := range []int64{0, 5, 16, 4097, int64(len(content)) - 3} {
+		buf := make([]byte, 100)
+		n, err := spool.ReadAt(buf, offset)
+		want := content[offset:min(offset+100, int64(len(content)))]
+		if !bytes.Equal(buf[:n], want) || (n < len(buf) && err != io.EOF) {
+			t.Errorf("ReadAt(%d) = %q, %v, want %q", offset, buf[:n], err, want)
+		}
+	}
+	if err := spool.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("spool %s still exists after Close(): %v", name, err)
+	}
+}
+
+func TestValidEntryName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"report.pdf", true},
+		{"docs/notes.txt", true},
+		{"./docs/notes.txt", true},
+		{"/etc/passwd", false},
+		{"../report.pdf", false},
+		{"docs/../../report.pdf", false},
+		{INTERNAL_PREFIX + "manifests/report.pdf", false},
+	}
+	for _, tt := range tests {
+		if got := validEntryName(tt.name); got != tt.valid {
+			t.Errorf("validEntryName(%q) = %v, want %v", tt.name, got, tt.valid)
+		}
+	}
+}
+
+// The files of the form are stored while they arrive, a chunk size after them cannot apply anymore
+func TestChunkSizeAfterFiles(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// Only holds a file that is refused by name, so nothing reaches minio
+	var archive bytes.Buffer
+	w := tar.NewWriter(&archive)
+	w.WriteHeader(&tar.Header{Name: "../report.pdf", Typeflag: tar.TypeReg, Mode: 0644, Size: 5})
+	w.Write([]byte("first"))
+	w.Close()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("archive", "files.tar")
+	part.Write(archive.Bytes())
+	form.WriteField("chunk-size", "64MB")
+	form.Close()
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/upload/file", &body)
+	c.Request.Header.Set("Content-Type", form.FormDataContentType())
+	testFileHandler().UploadFilesHandler(c)
+	if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "chunk-size") {
+		t.Errorf("UploadFilesHandler() = %d %s, want 400 for the chunk size", recorder.Code, recorder.Body)
+	}
+}
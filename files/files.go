@@ -1,61 +1,55 @@
 package files
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"log"
-	"math"
+	"mime"
 	"net/http"
-	"regexp"
-	"strconv"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"taurus-minio/api"
 	"taurus-minio/client"
 	"taurus-minio/encryption"
+	"taurus-minio/logging"
+	"taurus-minio/metrics"
+	"taurus-minio/tracing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/minio/minio-go/v7"
+	"github.com/rs/xid"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-// TODO: move to config
-const BUFFER_SIZE uint64 = 16384
+// Block size of files whose manifest does not record one, e.g. files uploaded before it was configurable
+const BUFFER_SIZE uint64 = client.DEFAULT_BLOCK_SIZE
 
-// Parses chunk size from string and returns number of bytes to process in each chunk
-func parseChunkSize(size string) (uint64, error) {
-	r, _ := regexp.Compile("([0-9]+)([A-z]*)B")
-	matches := r.FindStringSubmatch(size)
-	log.Printf("Matches %s \n", matches)
-	if len(matches) != 3 {
-		log.Fatalln("Matches should be 3")
-		return 0, errors.New("Chunk size must be in format digit + size. E.g. 1MB")
-	}
-	mult, err := strconv.Atoi(matches[1])
-	if err != nil {
-		log.Fatalf("Cant convert %s\n to integer", matches[1])
-		return 0, errors.New("Chunk size digit must be integer")
-	}
+// Smallest chunk size, smaller chunks would not even hold the file id and a block
+const MIN_CHUNK_SIZE = client.MIN_CHUNK_SIZE
 
-	number := float64(mult)
+// Encrypted chunks spooled to disk at once for retries, chunks beyond are streamed instead
+const MAX_SPOOL_SIZE = 1 << 30
 
-	scale := matches[2]
+// Request headers with metadata of PUT uploads, e.g. X-Meta-Author
+const METADATA_HEADER_PREFIX = "X-Meta-"
 
-	fac := float64(1)
+// Limit of the metadata of a file, keys and values together. It is stored in the manifest
+const MAX_METADATA_SIZE = 2048
 
-	switch scale {
-	case "K":
-		fac = math.Pow(10, 3)
-	case "M":
-		fac = math.Pow(10, 6)
-	case "G":
-		fac = math.Pow(10, 9)
-	case "T":
-		fac = math.Pow(10, 12)
-	case "P":
-		fac = math.Pow(10, 15)
-	default:
-		fac = 1
+// Parses chunk size from string and returns number of bytes to process in each chunk
+func parseChunkSize(size string) (uint64, error) {
+	bytes, err := client.ParseSize(size)
+	if err != nil {
+		return 0, fmt.Errorf("chunk-size %w", err)
+	}
+	if bytes < MIN_CHUNK_SIZE {
+		return 0, fmt.Errorf("chunk-size %q must be at least 1KB", size)
 	}
-	// Dealing with whole numbers
-	return uint64(float64(number) * fac), nil
+	return bytes, nil
 }
 
 // Helper function to understand chunk size after encryption. Should change if different encryption is used
@@ -69,71 +63,455 @@ func getChunkName(filename string, id uint64) string {
 }
 
 type FileHandler struct {
-	minioClient   *client.MinioClient
-	cryptographer *encryption.Cryptographer
+	minioClient *client.MinioClient
+	// Swapped when the keys are rotated
+	keyring atomic.Pointer[encryption.Keyring]
+
+	// Uploads that have not committed their manifest yet, by upload id
+	uploadsMu sync.Mutex
+	uploads   map[string]time.Time
+
+	// Locks of file names whose manifests are being changed
+	namesMu sync.Mutex
+	names   map[string]*nameLock
+
+	// Bytes reserved by chunks encrypted to temporary files
+	spooled atomic.Int64
 }
 
 // Creates File Handler, responsible for handling file upload/download
-func InitFileHandler(minioClient *client.MinioClient, cryptographer *encryption.Cryptographer) *FileHandler {
-	return &FileHandler{
-		minioClient:   minioClient,
-		cryptographer: cryptographer,
+func InitFileHandler(minioClient *client.MinioClient, keyring *encryption.Keyring) *FileHandler {
+	fh := &FileHandler{
+		minioClient: minioClient,
+		uploads:     make(map[string]time.Time),
+		names:       make(map[string]*nameLock),
+	}
+	fh.keyring.Store(keyring)
+	return fh
+}
+
+// Current server settings, they can change on configuration reload
+func (fh *FileHandler) configuration() client.ServerConfiguration {
+	return fh.minioClient.GetServerConfiguration()
+}
+
+// Plaintext bytes encrypted as one block for new uploads
+func (fh *FileHandler) blockSize() uint64 {
+	blockSize := fh.configuration().BlockSize
+	if blockSize <= 0 {
+		return BUFFER_SIZE
 	}
+	return uint64(blockSize)
 }
 
 // Upload wrapper, takes reader and fileName
 // Encrypts the content received on file
 // and uploads the encrypted content
-func (fh *FileHandler) uploadFileWrapper(file io.Reader, filename string) (minio.UploadInfo, error) {
+// Lock protects the uploaded object, nil for no protection.
+// The request body is streamed and cannot be sent again, so the upload is attempted once.
+// Spooling it like chunks would need the whole file on disk
+func (fh *FileHandler) uploadFileWrapper(ctx context.Context, file io.Reader, filename string, cryptographer *encryption.Cryptographer, blockSize uint64, lock *client.ObjectLock) (minio.UploadInfo, error) {
+	ctx, span := tracing.Start(ctx, "minio.PutObject", attribute.String("object", filename))
+	defer span.End()
+
 	r, w := io.Pipe()
 	defer r.Close()
-	go fh.readEncryptWrite(file, w)
+	// Cancelling the request aborts the upload
+	stop := context.AfterFunc(ctx, func() { r.CloseWithError(ctx.Err()) })
+	defer stop()
+	go readEncryptWrite(ctx, file, w, cryptographer, blockSize)
 
-	return fh.minioClient.UploadFile(r, filename)
+	info, err := fh.minioClient.UploadLockedFile(ctx, r, filename, lock)
+	tracing.RecordError(span, err)
+	span.SetAttributes(attribute.Int64("size", info.Size))
+	return info, err
+}
+
+// Same as uploadFileWrapper, but encrypts the chunk to a temporary file first.
+// Unlike a stream, the file can be sent again, so a failed chunk upload is retried.
+// Chunks that would take the spooled bytes over MAX_SPOOL_SIZE are streamed and attempted once
+func (fh *FileHandler) uploadChunkWrapper(ctx context.Context, chunk io.Reader, chunkName string, chunkSize uint64, cryptographer *encryption.Cryptographer, blockSize uint64, lock *client.ObjectLock) (minio.UploadInfo, error) {
+	if !fh.reserveSpool(int64(chunkSize)) {
+		return fh.uploadFileWrapper(ctx, chunk, chunkName, cryptographer, blockSize, lock)
+	}
+	defer fh.reserveSpool(-int64(chunkSize))
+	spool, err := os.CreateTemp("", "taurus-chunk-*")
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	r, w := io.Pipe()
+	defer r.Close()
+	stop := context.AfterFunc(ctx, func() { r.CloseWithError(ctx.Err()) })
+	defer stop()
+	go readEncryptWrite(ctx, chunk, w, cryptographer, blockSize)
+	if _, err := io.Copy(spool, r); err != nil {
+		return minio.UploadInfo{}, err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return minio.UploadInfo{}, err
+	}
+
+	ctx, span := tracing.Start(ctx, "minio.PutObject", attribute.String("object", chunkName))
+	defer span.End()
+	info, err := fh.minioClient.UploadLockedFile(ctx, spool, chunkName, lock)
+	tracing.RecordError(span, err)
+	span.SetAttributes(attribute.Int64("size", info.Size))
+	return info, err
+}
+
+// Reserves disk space for a chunk spool, negative sizes release it again
+func (fh *FileHandler) reserveSpool(size int64) bool {
+	if fh.spooled.Add(size) > MAX_SPOOL_SIZE && size > 0 {
+		fh.spooled.Add(-size)
+		return false
+	}
+	return true
+}
+
+// Settings of a single upload, taken from the form fields or headers of the request
+type uploadOptions struct {
+	// Plaintext bytes per chunk, only used if chunking is enabled
+	chunkSize uint64
+	// Given by the client, detected from the content if empty
+	contentType string
+	metadata    map[string]string
+	lock        *client.ObjectLock
+	// Name and modification time of the file on the client, the stored name and upload time if empty
+	fileName string
+	modTime  time.Time
+}
+
+// Parses the settings of an upload. Errors are caused by the request
+func (fh *FileHandler) parseUploadOptions(chunkSize string, contentType string, metadata map[string]string, mode string, retainUntil string, legalHold string) (*uploadOptions, error) {
+	options := &uploadOptions{contentType: contentType, metadata: metadata}
+	size := 0
+	for key, value := range metadata {
+		size += len(key) + len(value)
+	}
+	if size > MAX_METADATA_SIZE {
+		return nil, fmt.Errorf("metadata must not be larger than %d bytes", MAX_METADATA_SIZE)
+	}
+
+	// Optional WORM protection of the upload
+	lock, err := parseObjectLock(mode, retainUntil, legalHold)
+	if err != nil {
+		return nil, err
+	}
+	if lock != nil && !fh.minioClient.UseObjectLocking() {
+		return nil, errors.New("Object locking is not enabled for the bucket")
+	}
+	options.lock = lock
+
+	if fh.minioClient.UseChunking() {
+		// Use chunks enabled, get chunk size from file options
+		if chunkSize == "" {
+			chunkSize = fh.configuration().DefaultChunkSize
+		}
+		if options.chunkSize, err = parseChunkSize(chunkSize); err != nil {
+			return nil, err
+		}
+	}
+	return options, nil
 }
 
 // Main handler for uploading files
-// Uses gin context to retrieve data
+// Reads the multipart form part by part and encrypts every upload part while it arrives.
+// Fields may come before or after the files, except for the chunk size which the files are stored with.
+// Several files or an archive are answered with a result per file, a failed file does not fail the others
 func (fh *FileHandler) UploadFilesHandler(c *gin.Context) {
-	// Fetch the file, dont read it and start stream go routine
-	file, header, err := c.Request.FormFile("upload")
+	defer func() { metrics.CountUpload(c.Writer.Status()) }()
+	ctx := c.Request.Context()
+	form, err := api.NewForm(c)
 	if err != nil {
-		log.Fatalln(err)
+		respondFormError(c, err)
+		return
 	}
-	filename := header.Filename
 
-	// No chunk usage. Simple upload/download
-	if !fh.minioClient.UseChunking() {
-		info, errUpload := fh.uploadFileWrapper(file, filename)
-		if errUpload != nil {
+	// Metadata headers apply to every file of the form
+	metadata := metadataFromHeader(c.Request.Header)
+	fields := make(map[string]string)
+	results := make([]*fileResult, 0)
+	batch := false
+	defer func() {
+		// Uploads that were not committed are removed
+		for _, result := range results {
+			if result.upload != nil {
+				result.upload.abort(ctx)
+			}
+		}
+	}()
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			respondFormError(c, err)
+			return
+		}
+
+		// The protection fields may follow the files, the lock is set once the form is complete
+		switch part.FormName() {
+		case "upload":
+			batch = batch || len(results) > 0
+			var result *fileResult
+			result, err = fh.storePart(ctx, part.FileName(), part.Header.Get("Content-Type"), time.Time{}, part, fields["chunk-size"], metadata)
+			if result != nil {
+				results = append(results, result)
+			}
+		case "archive":
+			batch = true
+			var archived []*fileResult
+			archived, err = fh.storeArchive(ctx, part.FileName(), part, fields["chunk-size"], metadata)
+			results = append(results, archived...)
+		case "chunk-size":
+			// The files are stored with it while they arrive
+			if len(results) > 0 || batch {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"message": "chunk-size must be sent before the files",
+				})
+				return
+			}
+			fallthrough
+		default:
+			fields[part.FormName()], err = form.Value(part)
+		}
+		part.Close()
+		if err != nil {
+			respondFormError(c, err)
+			return
+		}
+	}
+
+	if len(results) == 0 && !batch {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "Form field upload or archive with a file is required",
+		})
+		return
+	}
+	options, err := fh.parseUploadOptions(fields["chunk-size"], "", metadata, fields["retention-mode"], fields["retain-until"], fields["legal-hold"])
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	failed := 0
+	for _, result := range results {
+		fh.finishUpload(ctx, result, options)
+		if result.err != nil {
+			failed++
+			logging.FromContext(ctx).Error("Failed to upload file", "file", result.Name, "error", result.err)
+		}
+		result.report()
+	}
+
+	if !batch {
+		if failed > 0 {
 			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": errUpload,
+				"message": "Error uploading file",
 			})
 			return
 		}
+		respondManifest(c, results[0].manifest)
+		return
+	}
+	status := http.StatusOK
+	if failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, gin.H{
+		"status": fmt.Sprintf("Uploaded %d of %d files", len(results)-failed, len(results)),
+		"files":  results,
+	})
+}
 
-		c.JSON(http.StatusOK, gin.H{
-			"status": "success",
-			"ETag":   info.ETag,
+// Answers a form that does not match the API document with 400, other errors come from reading the request
+func respondFormError(c *gin.Context, err error) {
+	var invalid *api.ValidationError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, invalid.Body())
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{
+		"message": "Could not read the form: " + err.Error(),
+	})
+}
+
+// Stores the request body as file/`name`, without form encoding.
+// Chunk size, content type, metadata, original name, modification time and protection are taken from the headers
+func (fh *FileHandler) PutFileHandler(c *gin.Context) {
+	defer func() { metrics.CountUpload(c.Writer.Status()) }()
+	header := c.Request.Header
+	options, err := fh.parseUploadOptions(header.Get("X-Chunk-Size"), header.Get("Content-Type"), metadataFromHeader(header),
+		header.Get("X-Retention-Mode"), header.Get("X-Retain-Until"), header.Get("X-Legal-Hold"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
 		})
-	} else {
-		// Use chunks enabled, get chunk size from file options
-		chunkSize := c.Request.FormValue("chunk-size")
-		log.Printf("Chunking file in size of %s \n", chunkSize)
-		byteSize, err := parseChunkSize(chunkSize)
-		if err != nil {
-			// Return error if unable to parse chunkSize
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"message": err,
+		return
+	}
+	// Original name and modification time of the file on the client
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		options.fileName = params["filename"]
+	}
+	if modified := header.Get("Last-Modified"); modified != "" {
+		if options.modTime, err = http.ParseTime(modified); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "Last-Modified must be an HTTP date",
 			})
 			return
 		}
+	}
+	fh.respondUpload(c, c.Param("name"), c.Request.Body, options)
+}
+
+// Stores the file and answers with its version and ETags
+func (fh *FileHandler) respondUpload(c *gin.Context, name string, file io.Reader, options *uploadOptions) {
+	ctx := c.Request.Context()
+	manifest, err := fh.storeFile(ctx, name, file, options)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to upload file", "file", name, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error uploading file",
+		})
+		return
+	}
+	respondManifest(c, manifest)
+}
+
+// Answers an upload with the version and ETags of the stored objects
+func respondManifest(c *gin.Context, manifest *Manifest) {
+	if !manifest.Chunked {
+		c.JSON(http.StatusOK, gin.H{
+			"status":    "success",
+			"ETag":      manifest.Objects[0].ETag,
+			"versionId": manifest.VersionID,
+		})
+		return
+	}
+	chunkTags := make([]string, 0, len(manifest.Objects))
+	for _, object := range manifest.Objects {
+		chunkTags = append(chunkTags, object.ETag)
+	}
+	// Response to client
+	c.JSON(http.StatusOK, gin.H{
+		"status":    fmt.Sprintf("Successfully uploaded %d chunks", len(manifest.Objects)-1),
+		"Tags":      chunkTags,
+		"versionId": manifest.VersionID,
+	})
+}
+
+// Objects of an upload that are stored but not visible yet
+type pendingUpload struct {
+	fh       *FileHandler
+	manifest Manifest
+	options  *uploadOptions
+}
+
+// Encrypts and stores the file as new version of name, in chunks if chunking is enabled.
+// The version is committed once every object is stored, data of failed uploads is removed
+func (fh *FileHandler) storeFile(ctx context.Context, filename string, file io.Reader, options *uploadOptions) (*Manifest, error) {
+	upload, err := fh.storeObjects(ctx, filename, file, options)
+	if err != nil {
+		return nil, err
+	}
+	if err := upload.commit(ctx); err != nil {
+		upload.abort(ctx)
+		return nil, err
+	}
+	return &upload.manifest, nil
+}
+
+// Makes the upload the current version of the file
+func (upload *pendingUpload) commit(ctx context.Context) error {
+	upload.manifest.CreatedAt = time.Now().UTC()
+	if err := upload.fh.commitManifest(ctx, &upload.manifest); err != nil {
+		return fmt.Errorf("committing upload: %w", err)
+	}
+	upload.fh.endUpload(upload.manifest.UploadID)
+	return nil
+}
+
+// Protects the stored objects of the upload, before it is committed
+func (upload *pendingUpload) lock(ctx context.Context, lock *client.ObjectLock) error {
+	if lock == nil {
+		return nil
+	}
+	for _, object := range upload.manifest.Objects {
+		if err := upload.fh.minioClient.LockObject(ctx, object.Name, lock); err != nil {
+			return fmt.Errorf("locking %s: %w", object.Name, err)
+		}
+	}
+	upload.manifest.Lock = lock
+	upload.options.lock = lock
+	return nil
+}
+
+// Removes the stored objects of the upload
+func (upload *pendingUpload) abort(ctx context.Context) {
+	// The request context may already be cancelled
+	upload.fh.removeUploadData(context.WithoutCancel(ctx), upload.manifest.UploadID)
+	upload.fh.endUpload(upload.manifest.UploadID)
+}
+
+// Encrypts and stores the objects of the file under a new upload id without committing them.
+// The returned upload has to be committed or aborted, the objects of a failed upload are removed already
+func (fh *FileHandler) storeObjects(ctx context.Context, filename string, file io.Reader, options *uploadOptions) (*pendingUpload, error) {
+	logger := logging.FromContext(ctx)
+	lock := options.lock
+
+	// Data is stored under a fresh upload id and only becomes visible once the manifest is committed.
+	// Settings are read once, so a configuration reload does not affect the running upload
+	uploadId := xid.New().String()
+	cryptographer := fh.activeKey()
+	fh.beginUpload(uploadId)
+	upload := &pendingUpload{fh: fh, options: options}
+	stored := false
+	defer func() {
+		if !stored {
+			upload.abort(ctx)
+		}
+	}()
+	manifest := &upload.manifest
+	*manifest = Manifest{
+		Name:      filename,
+		VersionID: uploadId,
+		UploadID:  uploadId,
+		Chunked:   options.chunkSize > 0,
+		BlockSize: fh.blockSize(),
+		KeyID:     cryptographer.KeyID(),
+		Lock:      lock,
+	}
+	info := &FileInfo{FileName: options.fileName, ModTime: options.modTime, Metadata: options.metadata}
+	if info.FileName == "" {
+		info.FileName = path.Base(filename)
+	}
+	if info.ModTime.IsZero() {
+		info.ModTime = time.Now().UTC()
+	}
+	file, info.ContentType = detectContentType(file, options.contentType, info.FileName)
+	counter := &countingReader{reader: file}
+	file = counter
+
+	// No chunk usage. Simple upload/download
+	if !manifest.Chunked {
+		info, errUpload := fh.uploadFileWrapper(ctx, file, getDataName(uploadId), cryptographer, manifest.BlockSize, lock)
+		if errUpload != nil {
+			return nil, errUpload
+		}
+		manifest.Objects = append(manifest.Objects, ManifestObject{Name: getDataName(uploadId), ETag: info.ETag, Size: info.Size})
+	} else {
+		byteSize := options.chunkSize
+		logger.Info("Chunking file", "file", filename, "chunkSize", byteSize)
 		// Create a chunk while reading
 		// As a "lazy" solution we just pipe bytes again for the chunk size
 		chunkId := uint64(0)
-		var chunkTags []string
 
-		chunkBufferSize := BUFFER_SIZE
+		chunkBufferSize := manifest.BlockSize
 		// For very small chunks.
 		if byteSize < chunkBufferSize {
 			chunkBufferSize = byteSize / 4
@@ -145,7 +523,7 @@ func (fh *FileHandler) UploadFilesHandler(c *gin.Context) {
 		outBuf := make([]byte, chunkBufferSize)
 		isEof := false
 		for {
-			chunkName := getChunkName(filename, chunkId)
+			chunkName := getChunkName(getDataName(uploadId), chunkId)
 			// 16 bytes of fileid
 			currentChunkSize := uint64(16)
 			nextBlock := uint64(0)
@@ -170,20 +548,26 @@ func (fh *FileHandler) UploadFilesHandler(c *gin.Context) {
 						smallBuff := make([]byte, spaceForNextWrite)
 						n, err = file.Read(smallBuff)
 						if err != nil && err != io.EOF {
-							log.Fatalln(err)
+							// Fails the upload of the chunk, e.g. when the client went away
+							w_chunk.CloseWithError(err)
+							return
 						}
 						copy(outBuf, smallBuff)
 					} else {
 						// Normal read
 						n, err = file.Read(outBuf)
 						if err != nil && err != io.EOF {
-							log.Fatalln(err)
+							w_chunk.CloseWithError(err)
+							return
 						}
 					}
 
 					// The actual size on disk after encryption. Use this to keep track of chunk sizes
 					currentChunkSize += uint64(n) + getEncryptionOverhead()
-					w_chunk.Write(outBuf[:n])
+					if _, errWrite := w_chunk.Write(outBuf[:n]); errWrite != nil {
+						// Upload of the chunk was aborted
+						break
+					}
 
 					if err == io.EOF {
 						isEof = true
@@ -196,169 +580,411 @@ func (fh *FileHandler) UploadFilesHandler(c *gin.Context) {
 				w_chunk.Close()
 			}()
 
-			info, errUpload := fh.uploadFileWrapper(r_chunk, chunkName)
+			info, errUpload := fh.uploadChunkWrapper(ctx, r_chunk, chunkName, byteSize, cryptographer, manifest.BlockSize, lock)
 			chunkId++
 			// Close chunk as it read EOF when it returned from wrapper
 			r_chunk.Close()
 
 			if errUpload != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"message": "Error uploading chunk",
-				})
-				return
+				return nil, fmt.Errorf("chunk %d: %w", chunkId-1, errUpload)
 			}
-			chunkTags = append(chunkTags, info.ETag)
+			metrics.CountChunk(metrics.UPLOAD)
+			manifest.Objects = append(manifest.Objects, ManifestObject{Name: chunkName, ETag: info.ETag, Size: info.Size})
 
 			// Can only happen after w_chunk.Close() is called
 			if isEof {
-				log.Printf("Finished processing all chunks")
+				logger.Info("Finished processing all chunks", "file", filename, "chunks", chunkId)
 				break
 			}
 		}
+	}
 
-		// Response to client
-		c.JSON(http.StatusOK, gin.H{
-			"status": fmt.Sprintf("Successfully uploaded %d chunks", chunkId-1),
-			"Tags":   chunkTags,
-		})
+	info.Size = counter.size
+	sealed, err := sealInfo(info, cryptographer, uploadId)
+	if err != nil {
+		return nil, err
 	}
+	manifest.Info = sealed
+
+	stored = true
+	return upload, nil
+}
+
+// Objects of a file uploaded before manifests were introduced.
+// Whether the file was chunked is only known from the configuration.
+// No objects are returned if the file does not exist
+func (fh *FileHandler) legacyObjects(ctx context.Context, name string) (bool, []string, error) {
+	if !fh.minioClient.UseChunking() {
+		if _, err := fh.minioClient.StatObject(ctx, name); err != nil {
+			if client.IsNotFound(err) {
+				return false, nil, nil
+			}
+			return false, nil, err
+		}
+		return false, []string{name}, nil
+	}
+	// Retrieve a list of chunks. Max of 1000 chunks supported
+	chunks, err := fh.minioClient.GetAllChunks(ctx, name)
+	if err != nil {
+		return true, nil, err
+	}
+	var objects []string
+	for i := 0; i < len(chunks); i++ {
+		objects = append(objects, getChunkName(name, uint64(i)))
+	}
+	return true, objects, nil
 }
 
 // File retrieval handler
 // Retrieves file with a given uri parameter on file/`name`
+// An older version is retrieved with the `version` query parameter, `inline=true` lets browsers display it.
+// A single byte range can be requested with the Range header
 func (fh *FileHandler) GetFileFromIDHandler(c *gin.Context) {
-	name := c.Param("name")
+	defer func() { metrics.CountDownload(c.Writer.Status()) }()
+	fh.serveFile(c, c.Param("name"), c.Query("version"))
+}
 
-	// Create pipe, used for both chunk and non chunk modes
-	r, w := io.Pipe()
-	defer r.Close()
+// Answers with the decrypted file, or the requested range of it
+func (fh *FileHandler) serveFile(c *gin.Context, name string, version string) {
+	ctx := c.Request.Context()
 
-	if !fh.minioClient.UseChunking() {
-		reader := fh.minioClient.DownloadFile(name)
-		defer reader.Close()
+	// Files uploaded before manifests were introduced are found by their name
+	manifest, err := fh.findManifest(ctx, name, version)
+	if errors.Is(err, ErrFileNotFound) {
+		message := "Could not find file"
+		if version != "" {
+			message = "Could not find file version"
+		}
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": message,
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Could not read file manifest",
+		})
+		return
+	}
+	keys := fh.keysFor(manifest.KeyID)
+	if len(keys) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Encryption key of the file is not configured",
+		})
+		return
+	}
 
-		go fh.readDecryptWrite(reader, w)
+	info, err := manifest.fileInfo(keys)
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to decrypt file info", "file", name, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Could not read file info",
+		})
+		return
+	}
+	size := int64(-1)
+	if manifest.Info != nil {
+		// Clients notice downloads that end early by the length
+		size = info.Size
+	}
 
-	} else {
-		// Retrieve a list of chunks. Max of 1000 chunks supported
-		chunks := fh.minioClient.GetAllChunks(name)
-		chunkCount := len(chunks)
-		if chunkCount == 0 {
-			c.JSON(http.StatusNotFound, gin.H{
-				"message": "Could not find file",
+	status := http.StatusOK
+	offset, length := int64(0), size
+	if header := c.GetHeader("Range"); header != "" {
+		if size < 0 {
+			// Files uploaded before the size was recorded
+			if size, err = fh.plaintextSize(ctx, manifest); err != nil {
+				logging.FromContext(ctx).Error("Failed to compute file size", "file", name, "error", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"message": "Could not read file size",
+				})
+				return
+			}
+			length = size
+		}
+		start, count, err := parseRange(header, size)
+		if errors.Is(err, errRangeNotSatisfiable) {
+			c.Header("Content-Range", fmt.Sprintf("bytes */%d", size))
+			c.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{
+				"message": "Range is outside of the file",
 			})
 			return
 		}
-		// TODO: move to config
-		routineCount := 8
-		chunkers := make(map[int]chan []byte)
-
-		// Start routines and create their channels of size 1 for orderly deliver
-		for j := 0; j < routineCount; j++ {
-			// TODO: test with bigger channels if possible for routines to download more chunks
-			chunkers[j] = make(chan []byte, 1)
-			count := chunkCount / routineCount
-			remainder := chunkCount - count*routineCount
-			if remainder > j {
-				count += 1
-			}
-			go fh.retrieveAllChunks(j, routineCount, count, name, chunkers[j])
+		// Malformed and multiple ranges are ignored, the whole file is sent
+		if err == nil {
+			status, offset, length = http.StatusPartialContent, start, count
+		}
+	}
+
+	// Chunks before the range are not fetched at all
+	manifest, skip, err := fh.skipObjects(ctx, manifest, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Could not download file",
+		})
+		return
+	}
+	reader, err := fh.openFile(ctx, manifest, keys)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Could not download file",
+		})
+		return
+	}
+	defer reader.Close()
+	// Blocks before the range are decrypted and authenticated, but not sent
+	if _, err := io.CopyN(io.Discard, reader, skip); err != nil {
+		logging.FromContext(ctx).Error("Failed to read file up to the range", "file", name, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Could not download file",
+		})
+		return
+	}
+
+	// Type, original name and metadata recorded on upload
+	setInfoHeaders(c, name, info, c.Query("inline") == "true")
+	if size >= 0 {
+		c.Header("Accept-Ranges", "bytes")
+	}
+	var body io.Reader = reader
+	if status == http.StatusPartialContent {
+		c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+		body = io.LimitReader(reader, length)
+	}
+
+	// Reader response. Will start serving part of response as soon as the first block is decrypted
+	c.DataFromReader(status, length, info.responseType(), body, nil)
+}
+
+// Decrypted content of a stored file, closing it stops the routines fetching it
+type fileReader struct {
+	*io.PipeReader
+	stop func()
+}
+
+func (reader *fileReader) Close() error {
+	reader.stop()
+	return reader.PipeReader.Close()
+}
+
+// Opens the plaintext of the file, decrypted with one of keys while it is read.
+// Chunks are fetched by DownloadRoutines routines in parallel and delivered in order.
+// The reader fails if an object cannot be fetched or decrypted
+func (fh *FileHandler) openFile(ctx context.Context, manifest *Manifest, keys []*encryption.Cryptographer) (io.ReadCloser, error) {
+	objects := manifest.objectNames()
+	blockSize := manifest.blockSize()
+
+	// Create pipe, used for both chunk and non chunk modes
+	r, w := io.Pipe()
+
+	logger := logging.FromContext(ctx)
+	// Cancelling the request, e.g. on shutdown, or a failing chunk stops the reader and the routines feeding it
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(ctx, func() { r.CloseWithError(context.Cause(ctx)) })
+	reader := &fileReader{PipeReader: r, stop: func() {
+		stop()
+		cancel(nil)
+	}}
+	if !manifest.Chunked {
+		// Span covers the whole fetch, the body is read long after the request was answered
+		getCtx, getSpan := tracing.Start(ctx, "minio.GetObject", attribute.String("object", objects[0]))
+		object, err := fh.minioClient.DownloadFile(ctx, objects[0])
+		if err != nil {
+			getSpan.End()
+			reader.Close()
+			logger.Error("Error downloading object", "object", objects[0], "error", err)
+			return nil, err
+		}
+		reader.stop = func() {
+			stop()
+			cancel(nil)
+			object.Close()
 		}
 
-		// Ordered delivery. Retrieve from each chunk channel which is blocking.
 		go func() {
-			for i := 0; i < chunkCount; i++ {
-				log.Printf("Reading chunk %d\n", i)
-				routineId := i % routineCount
-				data := <-chunkers[routineId]
-				w.Write(data)
-			}
-			w.Close()
+			defer getSpan.End()
+			readDecryptWrite(getCtx, object, w, keys, blockSize)
 		}()
+		return reader, nil
 	}
 
-	// resulting file name
-	extraHeaders := map[string]string{
-		"Content-Disposition": fmt.Sprintf(`attachment; filename="%s"`, name),
+	chunkCount := len(objects)
+	routineCount := fh.configuration().DownloadRoutines
+	chunkers := make(map[int]chan []byte)
+
+	// Start routines and create their channels of size 1 for orderly deliver
+	for j := 0; j < routineCount; j++ {
+		// TODO: test with bigger channels if possible for routines to download more chunks
+		chunkers[j] = make(chan []byte, 1)
+		count := chunkCount / routineCount
+		remainder := chunkCount - count*routineCount
+		if remainder > j {
+			count += 1
+		}
+		go fh.retrieveAllChunks(ctx, j, routineCount, count, objects, keys, blockSize, chunkers[j], cancel)
 	}
 
-	// Reader response. Will start serving part of response as soon as womething is written to `w`(Writer)
-	c.DataFromReader(http.StatusOK, -1, "application/octet-stream", r, extraHeaders)
+	// Ordered delivery. Retrieve from each chunk channel which is blocking.
+	go func() {
+		for i := 0; i < chunkCount; i++ {
+			logger.Debug("Reading chunk", "chunk", i)
+			_, span := tracing.Start(ctx, "chunk.deliver", attribute.Int("chunk", i))
+			start := time.Now()
+			routineId := i % routineCount
+			var data []byte
+			select {
+			case data = <-chunkers[routineId]:
+			case <-ctx.Done():
+				span.End()
+				w.CloseWithError(context.Cause(ctx))
+				return
+			}
+			span.SetAttributes(attribute.Float64("wait.seconds", time.Since(start).Seconds()))
+			_, err := w.Write(data)
+			span.End()
+			if err != nil {
+				return
+			}
+		}
+		w.Close()
+	}()
+	return reader, nil
 }
 
 // Single go routine code for retrieving the chunks it is reponsible for
 // Routine id is a number [0-routineCount)
 // Chunk count is the number of chunks this routine will have to retrieve
-// Name is the file name of the chunked-file we are trying to retrieve
+// Chunks are the object names of all chunks of the file in order, encrypted in blocks of blockSize with one of keys
 // Result should be a channel of byte array of size 1.
+// Fail is called with the error if a chunk can not be retrieved, which aborts the download
 //
 // So given 3 routines and 7 chunks:
 // routine 1 (id=0) will fetch chunks: 1,4,7
 // routine 2 (id=1) will fetch chunks: 2,5
 // routine 3 (id=1) will fetch chunks: 3,6
 // The chunks are written to a channel of size 1 which is not fetching next chunk until current is read
-func (fh *FileHandler) retrieveAllChunks(id, routineCount, chunkCount int, name string, result chan []byte) {
-	log.Printf("Retreiving %d chunks. Id: %d", chunkCount, id)
+func (fh *FileHandler) retrieveAllChunks(ctx context.Context, id, routineCount, chunkCount int, chunks []string, keys []*encryption.Cryptographer, blockSize uint64, result chan []byte, fail context.CancelCauseFunc) {
+	defer metrics.StartChunkWorker()()
+	logger := logging.FromContext(ctx)
+	logger.Debug("Retrieving chunks", "routine", id, "chunks", chunkCount)
 	for i := 0; i < chunkCount; i++ {
-		chunkId := uint(i*routineCount + id)
-		chunkName := getChunkName(name, uint64(chunkId))
-		log.Printf("Downloading chunk: %s", chunkName)
-		chunkReader := fh.minioClient.DownloadFile(chunkName)
+		chunkId := i*routineCount + id
+		chunkName := chunks[chunkId]
+		logger.Debug("Downloading chunk", "object", chunkName)
+		chunkCtx, chunkSpan := tracing.Start(ctx, "chunk.fetch", attribute.Int("chunk", chunkId), attribute.Int("routine", id))
+		getCtx, getSpan := tracing.Start(chunkCtx, "minio.GetObject", attribute.String("object", chunkName))
+		chunkReader, err := fh.minioClient.DownloadFile(ctx, chunkName)
+		if err != nil {
+			logger.Error("Error downloading chunk", "object", chunkName, "error", err)
+			getSpan.End()
+			chunkSpan.End()
+			fail(err)
+			return
+		}
 
 		r, w := io.Pipe()
 
-		go fh.readDecryptWrite(chunkReader, w)
-		var chunkBuff []byte
-		readBuf := make([]byte, BUFFER_SIZE)
-		for {
-			n, err := r.Read(readBuf)
-			if err != nil && err != io.EOF {
-				log.Fatalf("%s,%s \n", name, err)
-			}
-			chunkBuff = append(chunkBuff, readBuf[:n]...)
-			if err == io.EOF {
-				break
+		go readDecryptWrite(getCtx, chunkReader, w, keys, blockSize)
+		chunkBuff, err := io.ReadAll(r)
+		r.Close()
+		chunkReader.Close()
+		getSpan.End()
+		if err != nil {
+			// Cancelled downloads end here as well, as the minio reader fails with the context
+			if ctx.Err() == nil {
+				logger.Error("Failed to decrypt chunk", "object", chunkName, "error", err)
 			}
+			chunkSpan.End()
+			fail(err)
+			return
 		}
-		r.Close()
-		log.Printf("Decrypted chunk %d\n", chunkId)
+		logger.Debug("Decrypted chunk", "chunk", chunkId)
 		// write to channel for retrieval
-		result <- chunkBuff
+		metrics.CountChunk(metrics.DOWNLOAD)
+		start := time.Now()
+		select {
+		case result <- chunkBuff:
+		case <-ctx.Done():
+			// Download was aborted, nobody will read the chunk
+			chunkSpan.End()
+			return
+		}
+		// Time spent waiting for earlier chunks to be delivered
+		chunkSpan.SetAttributes(attribute.Float64("handoff.wait.seconds", time.Since(start).Seconds()))
+		chunkSpan.End()
 	}
 }
 
 // function to decrypt the current file being read.
 // Reads data from the reader
 // Reads fileId first for decryption additional data
+// Reads encrypted blocks of blockSize plaintext bytes
+// Uses the key of keys that decrypts the first block
 // Reads encrypted file content
 // Writer writes the decrypted data
 // Once file is processed writer is closed which sends EOF to the underlying PipeReader
-func (fh *FileHandler) readDecryptWrite(reader io.Reader, w *io.PipeWriter) {
+func readDecryptWrite(ctx context.Context, reader io.Reader, w *io.PipeWriter, keys []*encryption.Cryptographer, blockSize uint64) {
 	defer w.Close()
+	// Split time between reading from minio and decrypting to find the bottleneck
+	_, span := tracing.Start(ctx, "decrypt")
+	defer span.End()
+	var readTime, decryptTime time.Duration
+	total := 0
 
 	// Read file ID first for decryption
 	fileId := make([]byte, 16)
-	_, err := reader.Read(fileId)
+	n, err := readBlock(reader, fileId)
 
 	if err != nil && err != io.EOF {
-		log.Fatalln(err)
+		// Read errors, e.g. a cancelled request, fail the reader of the pipe
+		w.CloseWithError(err)
+		return
 	}
+	metrics.AddCiphertextBytes(metrics.DOWNLOAD, n)
 
 	// Read file size + IV(12bytes) + AES GCM 16 Bytes
 	// https://stackoverflow.com/questions/67028762/why-aes-256-with-gcm-adds-16-bytes-to-the-ciphertext-size
-	outBuf := make([]byte, BUFFER_SIZE+12+16)
+	outBuf := make([]byte, blockSize+12+16)
 	// count blocks for integrity check
 	blockId := uint64(0)
+	var cryptographer *encryption.Cryptographer
+	defer func() {
+		span.SetAttributes(
+			attribute.Int64("blocks", int64(blockId)),
+			attribute.Int("bytes", total),
+			attribute.Float64("read.seconds", readTime.Seconds()),
+			attribute.Float64("decrypt.seconds", decryptTime.Seconds()),
+		)
+	}()
 	for {
-		n, err := reader.Read(outBuf)
+		start := time.Now()
+		n, err := readBlock(reader, outBuf)
+		readTime += time.Since(start)
 		if err != nil && err != io.EOF {
-			log.Fatalln(err)
+			w.CloseWithError(err)
+			return
 		}
 
 		//decrypt here
-		decryptedBytes := fh.cryptographer.Decrypt(outBuf[:n], fileId, blockId)
+		start = time.Now()
+		if blockId == 0 {
+			cryptographer = matchKey(keys, outBuf[:n], fileId)
+		}
+		decryptedBytes, errDecrypt := cryptographer.TryDecrypt(outBuf[:n], fileId, blockId)
+		if errDecrypt != nil && n > 0 {
+			w.CloseWithError(fmt.Errorf("block %d: %w", blockId, errDecrypt))
+			return
+		}
+		elapsed := time.Since(start)
+		decryptTime += elapsed
+		total += len(decryptedBytes)
+		metrics.ObserveCrypto(metrics.DECRYPT, len(decryptedBytes), elapsed)
 		if n > 0 {
-			w.Write(decryptedBytes)
+			metrics.AddCiphertextBytes(metrics.DOWNLOAD, n)
+			metrics.AddPlaintextBytes(metrics.DOWNLOAD, len(decryptedBytes))
+			if _, err := w.Write(decryptedBytes); err != nil {
+				// Reader is gone, e.g. the request was cancelled
+				return
+			}
 		}
 		if err == io.EOF {
 			break
@@ -367,34 +993,72 @@ func (fh *FileHandler) readDecryptWrite(reader io.Reader, w *io.PipeWriter) {
 	}
 }
 
+// Fills buf unless the reader ends. Readers like minio objects or pipes return less than a block per Read.
+// Objects stored with full blocks but the last stay readable. Chunks the first release wrote with a chunk size
+// below BUFFER_SIZE used blocks of a quarter of the chunk size, those fail authentication and cannot be read
+func readBlock(reader io.Reader, buf []byte) (int, error) {
+	n, err := io.ReadFull(reader, buf)
+	if err == io.ErrUnexpectedEOF {
+		return n, io.EOF
+	}
+	return n, err
+}
+
 // Function to encrypt current file being read.
 // Generates unique file id of 16bytes
-// Reads "plaintext" from `file`
-// Encrypts the data
+// Reads "plaintext" from `file` in blocks of blockSize
+// Encrypts the data with cryptographer
 // writes the encrypted data to pipe writer
 // Closed writer signals that encryption is done and reader has reached EOF
-func (fh *FileHandler) readEncryptWrite(file io.Reader, w *io.PipeWriter) {
+func readEncryptWrite(ctx context.Context, file io.Reader, w *io.PipeWriter, cryptographer *encryption.Cryptographer, blockSize uint64) {
 	defer w.Close()
+	_, span := tracing.Start(ctx, "encrypt")
+	defer span.End()
+	var encryptTime time.Duration
+	total := 0
 	// Generate unique file ID
-	fileId := fh.cryptographer.GenerateIV(16)
+	fileId := cryptographer.GenerateIV(16)
 	w.Write(fileId)
+	metrics.AddCiphertextBytes(metrics.UPLOAD, len(fileId))
 
 	// count blocks for integrity check
 	nextBlock := uint64(0)
-	outBuf := make([]byte, BUFFER_SIZE)
+	outBuf := make([]byte, blockSize)
+	defer func() {
+		span.SetAttributes(
+			attribute.Int64("blocks", int64(nextBlock)),
+			attribute.Int("bytes", total),
+			attribute.Float64("encrypt.seconds", encryptTime.Seconds()),
+		)
+	}()
 	for {
-		n, err := file.Read(outBuf)
+		// Every block but the last is full, decryption reads blocks of blockSize
+		n, err := readBlock(file, outBuf)
 		if err != nil && err != io.EOF {
-			log.Fatalln(err)
+			w.CloseWithError(err)
+			return
 		}
 
-		if err == io.EOF || n == 0 {
+		if n == 0 {
 			break
 		}
 		// Encrypt here
-		encrypted_text := fh.cryptographer.Encrypt(outBuf[:n], nextBlock, fileId)
-		w.Write(encrypted_text)
+		start := time.Now()
+		encrypted_text := cryptographer.Encrypt(outBuf[:n], nextBlock, fileId)
+		elapsed := time.Since(start)
+		encryptTime += elapsed
+		total += n
+		metrics.ObserveCrypto(metrics.ENCRYPT, n, elapsed)
+		metrics.AddPlaintextBytes(metrics.UPLOAD, n)
+		metrics.AddCiphertextBytes(metrics.UPLOAD, len(encrypted_text))
+		if _, err := w.Write(encrypted_text); err != nil {
+			// Upload was aborted
+			return
+		}
 		nextBlock++
+		if err == io.EOF {
+			break
+		}
 	}
 	w.Close()
 }
@@ -0,0 +1,164 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestReadDecryptWriteStopsWhenReaderIsGone(t *testing.T) {
+	fh := testFileHandler()
+	data := encryptForTest(fh, strings.Repeat("a", int(BUFFER_SIZE)*4), BUFFER_SIZE)
+
+	r, w := io.Pipe()
+	r.Close()
+	done := make(chan struct{})
+	go func() {
+		readDecryptWrite(context.Background(), bytes.NewReader(data), w, fh.keysFor(""), BUFFER_SIZE)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("readDecryptWrite() kept running after the reader was closed")
+	}
+}
+
+// Reader failing like a minio object after the request context was cancelled
+type cancelledReader struct{}
+
+func (cancelledReader) Read([]byte) (int, error) {
+	return 0, context.Canceled
+}
+
+func TestReadDecryptWriteFailsPipe(t *testing.T) {
+	fh := testFileHandler()
+	data := encryptForTest(fh, strings.Repeat("a", int(BUFFER_SIZE)*4), BUFFER_SIZE)
+	tampered := bytes.Clone(data)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name   string
+		reader io.Reader
+		want   error
+	}{
+		{"Cancelled read", io.MultiReader(bytes.NewReader(data[:16]), cancelledReader{}), context.Canceled},
+		// GCM does not export its authentication error
+		{"Tampered block", bytes.NewReader(tampered), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, w := io.Pipe()
+			go readDecryptWrite(context.Background(), tt.reader, w, fh.keysFor(""), BUFFER_SIZE)
+			_, err := io.ReadAll(r)
+			if err == nil || (tt.want != nil && !errors.Is(err, tt.want)) {
+				t.Errorf("reading decrypted data error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+// Objects as the first release stored them: the file id, full blocks and a shorter last block
+func TestDecryptShortReads(t *testing.T) {
+	fh := testFileHandler()
+	cryptographer := fh.activeKey()
+	plaintext := strings.Repeat("a", int(BUFFER_SIZE)*2) + "end"
+	fileId := cryptographer.GenerateIV(16)
+	stored := bytes.Clone(fileId)
+	for i := 0; i*int(BUFFER_SIZE) < len(plaintext); i++ {
+		block := plaintext[i*int(BUFFER_SIZE) : min((i+1)*int(BUFFER_SIZE), len(plaintext))]
+		stored = append(stored, cryptographer.Encrypt([]byte(block), uint64(i), fileId)...)
+	}
+
+	readers := map[string]func(io.Reader) io.Reader{
+		"Full reads":    func(r io.Reader) io.Reader { return r },
+		"One byte":      iotest.OneByteReader,
+		"Half reads":    iotest.HalfReader,
+		"EOF with data": iotest.DataErrReader,
+	}
+	for name, reader := range readers {
+		t.Run(name, func(t *testing.T) {
+			var got bytes.Buffer
+			if err := DecryptObject(context.Background(), reader(bytes.NewReader(stored)), &got, fh.keysFor(""), BUFFER_SIZE); err != nil {
+				t.Fatalf("DecryptObject() error = %v", err)
+			}
+			if got.String() != plaintext {
+				t.Errorf("DecryptObject() returned %d bytes, want %d", got.Len(), len(plaintext))
+			}
+		})
+	}
+}
+
+func TestWaitForUploads(t *testing.T) {
+	fh := testFileHandler()
+	fh.uploads = make(map[string]time.Time)
+	if running := fh.WaitForUploads(context.Background()); running != 0 {
+		t.Fatalf("WaitForUploads() without uploads = %d", running)
+	}
+
+	fh.beginUpload("first")
+	fh.beginUpload("second")
+	snapshot := fh.uploadsInProgress()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		fh.endUpload("first")
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if running := fh.WaitForUploads(ctx); running != 1 {
+		t.Errorf("WaitForUploads() = %d, want 1 upload still running", running)
+	}
+	// The snapshot of the garbage collector keeps uploads that ended since
+	if !snapshot["first"] || !snapshot["second"] {
+		t.Errorf("uploadsInProgress() = %v, want both uploads", snapshot)
+	}
+
+	fh.endUpload("second")
+	if running := fh.WaitForUploads(context.Background()); running != 0 {
+		t.Errorf("WaitForUploads() after all uploads ended = %d", running)
+	}
+}
+
+func TestParseChunkSize(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    uint64
+		wantErr bool
+	}{
+		{"64MB", 64000000, false},
+		{"1KB", 1000, false},
+		{"5000B", 5000, false},
+		{"64", 0, true},
+		{"64mb", 0, true},
+		{"10B", 0, true},
+		{"1XB", 0, true},
+		{"20000000PB", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.size, func(t *testing.T) {
+			got, err := parseChunkSize(tt.size)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseChunkSize(%q) = %d, %v, want %d, error %v", tt.size, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReserveSpool(t *testing.T) {
+	fh := testFileHandler()
+	if !fh.reserveSpool(MAX_SPOOL_SIZE - 10) {
+		t.Fatal("reserveSpool() within the limit failed")
+	}
+	if fh.reserveSpool(20) {
+		t.Error("reserveSpool() over the limit succeeded")
+	}
+	fh.reserveSpool(-(MAX_SPOOL_SIZE - 10))
+	if !fh.reserveSpool(20) || fh.spooled.Load() != 20 {
+		t.Errorf("reserveSpool() after releasing = %d bytes reserved, want 20", fh.spooled.Load())
+	}
+}
@@ -0,0 +1,173 @@
+package files
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"taurus-minio/client"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+)
+
+// Object removed (or to be removed in dry-run) by the garbage collector
+type GCObject struct {
+	Name         string    `json:"name"`
+	UploadID     string    `json:"uploadId"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// Result of a single garbage collection sweep
+type GCReport struct {
+	DryRun     bool       `json:"dryRun"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt time.Time  `json:"finishedAt"`
+	Objects    []GCObject `json:"objects"`
+	Bytes      int64      `json:"bytes"`
+	Errors     []string   `json:"errors,omitempty"`
+}
+
+// Removes data objects of uploads that failed before committing their manifest
+// and of uploads whose manifest was replaced by a newer upload
+type GarbageCollector struct {
+	fh            *FileHandler
+	configuration client.GCConfiguration
+
+	// Only one sweep at a time
+	mu sync.Mutex
+}
+
+// Creates garbage collector for the files handled by the given file handler
+func InitGarbageCollector(fh *FileHandler, configuration client.GCConfiguration) *GarbageCollector {
+	return &GarbageCollector{
+		fh:            fh,
+		configuration: configuration,
+	}
+}
+
+// Starts periodic sweeps if they are enabled in the configuration
+func (gc *GarbageCollector) Start() {
+	if !gc.configuration.Enabled || gc.configuration.Interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(gc.configuration.Interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := gc.Sweep(context.Background(), false); err != nil {
+				slog.Error("Garbage collection failed", "error", err)
+			}
+		}
+	}()
+}
+
+// Finds every data object that is not referenced by a manifest, not part of an upload
+// in progress and older than the grace period. Unless dryRun is set the objects are removed
+func (gc *GarbageCollector) Sweep(ctx context.Context, dryRun bool) (*GCReport, error) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+
+	report := &GCReport{
+		DryRun:    dryRun,
+		StartedAt: time.Now().UTC(),
+		Objects:   []GCObject{},
+	}
+
+	// Uploads running before the listing may commit at any time after it
+	running := gc.fh.uploadsInProgress()
+	// List data before manifests, so that an upload committing in between is seen as referenced
+	objects, err := gc.fh.minioClient.ListObjects(ctx, DATA_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+	referenced, err := gc.referencedObjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []minio.ObjectInfo
+	for _, object := range objects {
+		uploadId := getUploadId(object.Key)
+		if referenced[object.Key] || running[uploadId] || gc.fh.isUploading(uploadId) {
+			continue
+		}
+		if time.Since(object.LastModified) < gc.configuration.GracePeriod {
+			continue
+		}
+		candidates = append(candidates, object)
+	}
+	if len(candidates) > 0 {
+		// Uploads that started after the snapshot may have committed since the first read
+		referenced, err = gc.referencedObjects(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, object := range candidates {
+		if referenced[object.Key] {
+			continue
+		}
+		if !dryRun {
+			if errRemove := gc.fh.minioClient.RemoveObject(ctx, object.Key); errRemove != nil {
+				report.Errors = append(report.Errors, errRemove.Error())
+				continue
+			}
+		}
+		report.Objects = append(report.Objects, GCObject{
+			Name:         object.Key,
+			UploadID:     getUploadId(object.Key),
+			Size:         object.Size,
+			LastModified: object.LastModified,
+		})
+		report.Bytes += object.Size
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	slog.Info("Garbage collection finished", "dryRun", dryRun, "objects", len(report.Objects), "bytes", report.Bytes)
+	return report, nil
+}
+
+// Names of the objects referenced by any manifest, current, historic or in the trash
+func (gc *GarbageCollector) referencedObjects(ctx context.Context) (map[string]bool, error) {
+	manifests, err := gc.fh.readReferencedManifests(ctx)
+	if err != nil {
+		return nil, err
+	}
+	referenced := make(map[string]bool)
+	for _, manifest := range manifests {
+		for _, object := range manifest.Objects {
+			referenced[object.Name] = true
+		}
+	}
+	return referenced, nil
+}
+
+// Admin handler that runs a sweep. Pass `dry-run=true` to only list the objects
+func (gc *GarbageCollector) GCHandler(c *gin.Context) {
+	dryRun := false
+	if value := c.Query("dry-run"); value != "" {
+		var err error
+		dryRun, err = strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "dry-run must be true or false",
+			})
+			return
+		}
+	}
+
+	report, err := gc.Sweep(c.Request.Context(), dryRun)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,81 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How long the readiness checks may take in total
+const READY_CHECK_TIMEOUT = 5 * time.Second
+
+// Result of a single readiness check
+type HealthCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func healthCheck(err error) HealthCheck {
+	if err != nil {
+		return HealthCheck{Status: "failed", Error: err.Error()}
+	}
+	return HealthCheck{Status: "ok"}
+}
+
+// Liveness handler, answers as long as the process serves requests
+func (fh *FileHandler) HealthHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+	})
+}
+
+// Readiness handler, checks everything needed to serve uploads and downloads:
+// minio is reachable, the bucket exists, the encryption key is loaded and encrypts and decrypts correctly.
+// Answers 503 with the failed checks otherwise, so traffic is routed to other instances
+func (fh *FileHandler) ReadyHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), READY_CHECK_TIMEOUT)
+	defer cancel()
+
+	checks := make(map[string]HealthCheck)
+	exists, err := fh.minioClient.BucketExists(ctx)
+	checks["minio"] = healthCheck(err)
+	if err == nil && !exists {
+		err = errors.New("bucket does not exist")
+	}
+	checks["bucket"] = healthCheck(err)
+	checks["encryption"] = healthCheck(fh.checkEncryption())
+
+	status, code := "ready", http.StatusOK
+	for _, check := range checks {
+		if check.Status != "ok" {
+			status, code = "not ready", http.StatusServiceUnavailable
+		}
+	}
+	c.JSON(code, gin.H{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+// Encrypts and decrypts a block with the active key
+func (fh *FileHandler) checkEncryption() error {
+	keyring := fh.keyring.Load()
+	if keyring == nil || keyring.Active() == nil {
+		return errors.New("no encryption key loaded")
+	}
+	cryptographer := keyring.Active()
+	fileId := cryptographer.GenerateIV(16)
+	plaintext := cryptographer.GenerateIV(64)
+	decrypted, err := cryptographer.TryDecrypt(cryptographer.Encrypt(plaintext, 0, fileId), fileId, 0)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		return errors.New("decrypted data differs from the original")
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+package files
+
+import "testing"
+
+func TestCheckEncryption(t *testing.T) {
+	if err := testFileHandler().checkEncryption(); err != nil {
+		t.Errorf("checkEncryption() = %v", err)
+	}
+	if err := (&FileHandler{}).checkEncryption(); err == nil {
+		t.Error("checkEncryption() without keys succeeded")
+	}
+}
@@ -0,0 +1,142 @@
+package files
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"taurus-minio/encryption"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Bytes of the content used to detect its type, the amount http.DetectContentType looks at
+const SNIFF_SIZE = 512
+
+// Returned when the info block of a manifest cannot be decrypted
+var ErrInfoCorrupt = errors.New("file info cannot be decrypted")
+
+// What is known about a file besides its content. Stored encrypted in the manifest, so
+// neither minio nor anyone reading the bucket learns names, types or metadata of the plaintext
+type FileInfo struct {
+	ContentType string `json:"contentType"`
+	// Name of the file on the client, e.g. the file name of the form part
+	FileName string    `json:"fileName"`
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+	// Custom metadata given by the client, keys are lower case
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Encrypts the info with the key of the file, bound to the upload so it cannot be moved to another manifest
+func sealInfo(info *FileInfo, cryptographer *encryption.Cryptographer, uploadId string) ([]byte, error) {
+	plaintext, err := json.Marshal(info)
+	if err != nil {
+		return nil, err
+	}
+	return cryptographer.Encrypt(plaintext, 0, infoId(uploadId)), nil
+}
+
+// Additional data of the info block, data blocks use random 16 byte file ids instead
+func infoId(uploadId string) []byte {
+	return []byte("info:" + uploadId)
+}
+
+// Info of the file. Manifests written before it was recorded return an empty info
+func (manifest *Manifest) fileInfo(keys []*encryption.Cryptographer) (*FileInfo, error) {
+	if manifest.Info == nil {
+		return &FileInfo{}, nil
+	}
+	for _, cryptographer := range keys {
+		plaintext, err := cryptographer.TryDecrypt(manifest.Info, infoId(manifest.UploadID), 0)
+		if err != nil {
+			continue
+		}
+		var info FileInfo
+		if err := json.Unmarshal(plaintext, &info); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInfoCorrupt, err)
+		}
+		return &info, nil
+	}
+	return nil, ErrInfoCorrupt
+}
+
+// Content type given by the client or, if it gave none or the generic application/octet-stream,
+// detected from the first bytes of the file and the extension of name.
+// The returned reader replaces file, as the first bytes were read already
+func detectContentType(file io.Reader, given string, name string) (io.Reader, string) {
+	if given != "" && given != "application/octet-stream" {
+		return file, given
+	}
+	buffered := bufio.NewReaderSize(file, SNIFF_SIZE)
+	// Read errors are returned again by the next Read of the upload
+	head, _ := buffered.Peek(SNIFF_SIZE)
+	detected := http.DetectContentType(head)
+	if detected == "application/octet-stream" || strings.HasPrefix(detected, "text/plain") {
+		// Content alone cannot tell binary formats or text such as CSV apart
+		if byExtension := mime.TypeByExtension(path.Ext(name)); byExtension != "" {
+			return buffered, byExtension
+		}
+	}
+	return buffered, detected
+}
+
+// Counts the plaintext bytes read
+type countingReader struct {
+	reader io.Reader
+	size   int64
+}
+
+func (reader *countingReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+	reader.size += int64(n)
+	return n, err
+}
+
+// Custom metadata sent as X-Meta-* headers, keys are lower case without the prefix
+func metadataFromHeader(header http.Header) map[string]string {
+	metadata := make(map[string]string)
+	for key, values := range header {
+		if name, ok := strings.CutPrefix(key, METADATA_HEADER_PREFIX); ok && name != "" {
+			metadata[strings.ToLower(name)] = values[0]
+		}
+	}
+	return metadata
+}
+
+// Answers with the stored type, name, modification time and metadata of the file.
+// Inline files are shown by browsers instead of saved, sandboxed so uploaded HTML cannot run scripts
+func setInfoHeaders(c *gin.Context, name string, info *FileInfo, inline bool) {
+	fileName := info.FileName
+	if fileName == "" {
+		fileName = name
+	}
+	disposition := "attachment"
+	if inline {
+		disposition = "inline"
+		c.Header("Content-Security-Policy", "sandbox")
+	}
+	// Non ASCII names are encoded as filename*
+	c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": fileName}))
+	c.Header("X-Content-Type-Options", "nosniff")
+	if !info.ModTime.IsZero() {
+		c.Header("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+	for key, value := range info.Metadata {
+		c.Header(METADATA_HEADER_PREFIX+key, value)
+	}
+}
+
+// Content type of the response, application/octet-stream if none was recorded
+func (info *FileInfo) responseType() string {
+	if info.ContentType == "" {
+		return "application/octet-stream"
+	}
+	return info.ContentType
+}
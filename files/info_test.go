@@ -0,0 +1,75 @@
+package files
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileInfo(t *testing.T) {
+	fh := testFileHandler()
+	keys := fh.keyring.Load().All()
+	info := &FileInfo{
+		ContentType: "application/pdf",
+		FileName:    "Bericht über 2024.pdf",
+		Size:        4096,
+		ModTime:     time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		Metadata:    map[string]string{"author": "jane"},
+	}
+	sealed, err := sealInfo(info, fh.activeKey(), "upload")
+	if err != nil {
+		t.Fatalf("sealInfo() error = %v", err)
+	}
+	if strings.Contains(string(sealed), "jane") {
+		t.Errorf("sealInfo() leaks the metadata in plaintext")
+	}
+
+	manifest := &Manifest{UploadID: "upload", Info: sealed}
+	got, err := manifest.fileInfo(keys)
+	if err != nil || !reflect.DeepEqual(got, info) {
+		t.Errorf("fileInfo() = %+v, %v, want %+v", got, err, info)
+	}
+
+	// The block is bound to its upload
+	moved := &Manifest{UploadID: "other", Info: sealed}
+	if _, err := moved.fileInfo(keys); !errors.Is(err, ErrInfoCorrupt) {
+		t.Errorf("fileInfo() of a moved block error = %v, want ErrInfoCorrupt", err)
+	}
+
+	// Manifests written before the info was recorded know nothing about the file
+	legacy := &Manifest{UploadID: "upload"}
+	got, err = legacy.fileInfo(keys)
+	if err != nil || !reflect.DeepEqual(got, &FileInfo{}) {
+		t.Errorf("fileInfo() of a legacy manifest = %+v, %v", got, err)
+	}
+}
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		name    string
+		given   string
+		content string
+		want    string
+	}{
+		{"report.pdf", "application/pdf", "anything", "application/pdf"},
+		{"page", "", "<!DOCTYPE html><html></html>", "text/html; charset=utf-8"},
+		{"image", "application/octet-stream", "\x89PNG\r\n\x1a\n", "image/png"},
+		{"table.csv", "", "a,b\n1,2\n", "text/csv"},
+		{"notes", "", "plain words", "text/plain; charset=utf-8"},
+		{"empty", "", "", "text/plain; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		reader, got := detectContentType(strings.NewReader(tt.content), tt.given, tt.name)
+		// Types from the extension depend on the mime.types of the system, parameters may vary
+		if !strings.HasPrefix(got, tt.want) {
+			t.Errorf("detectContentType(%s) = %q, want %q", tt.name, got, tt.want)
+		}
+		// Sniffed bytes are still part of the upload
+		if data, _ := io.ReadAll(reader); string(data) != tt.content {
+			t.Errorf("detectContentType(%s) reader returned %q, want %q", tt.name, data, tt.content)
+		}
+	}
+}
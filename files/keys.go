@@ -0,0 +1,45 @@
+package files
+
+import "taurus-minio/encryption"
+
+// Swaps the keys used for new uploads and for reading files.
+// Running uploads and downloads keep the keys they started with
+func (fh *FileHandler) SetKeyring(keyring *encryption.Keyring) {
+	fh.keyring.Store(keyring)
+}
+
+// Key used to encrypt new uploads
+func (fh *FileHandler) activeKey() *encryption.Cryptographer {
+	return fh.keyring.Load().Active()
+}
+
+// Keys that may have encrypted a file with the given key id.
+// Files written before key ids were recorded may use any key, they are tried in order.
+// Empty if the key is not configured (anymore)
+func (fh *FileHandler) keysFor(keyId string) []*encryption.Cryptographer {
+	return keysOf(fh.keyring.Load(), keyId)
+}
+
+func keysOf(keyring *encryption.Keyring, keyId string) []*encryption.Cryptographer {
+	if keyId == "" {
+		return keyring.All()
+	}
+	if cryptographer := keyring.Get(keyId); cryptographer != nil {
+		return []*encryption.Cryptographer{cryptographer}
+	}
+	return nil
+}
+
+// Finds the key that decrypts the first block of a file.
+// Falls back to the first key, so a corrupt block fails as it would with a single key
+func matchKey(keys []*encryption.Cryptographer, block []byte, fileId []byte) *encryption.Cryptographer {
+	if len(keys) == 1 {
+		return keys[0]
+	}
+	for _, cryptographer := range keys {
+		if _, err := cryptographer.TryDecrypt(block, fileId, 0); err == nil {
+			return cryptographer
+		}
+	}
+	return keys[0]
+}
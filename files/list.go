@@ -0,0 +1,76 @@
+package files
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"taurus-minio/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// File as returned by the file list
+type FileEntry struct {
+	Name        string    `json:"name"`
+	VersionID   string    `json:"versionId"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"contentType,omitempty"`
+	ModTime     time.Time `json:"modTime"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Chunked     bool      `json:"chunked"`
+}
+
+// Lists the current version of every file whose name starts with the `prefix` query parameter, sorted by name.
+// Files uploaded before manifests were introduced are not listed
+func (fh *FileHandler) ListFilesHandler(c *gin.Context) {
+	ctx := c.Request.Context()
+	logger := logging.FromContext(ctx)
+	prefix := c.Query("prefix")
+	manifests, err := fh.readManifestsUnder(ctx, getManifestName(prefix))
+	if err != nil {
+		logger.Error("Failed to list files", "prefix", prefix, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Could not list files",
+		})
+		return
+	}
+
+	result := make([]FileEntry, 0, len(manifests))
+	for _, manifest := range manifests {
+		entry := FileEntry{
+			Name:      manifest.Name,
+			VersionID: manifest.version(),
+			CreatedAt: manifest.CreatedAt,
+			Chunked:   manifest.Chunked,
+		}
+		info, err := manifest.fileInfo(fh.keysFor(manifest.KeyID))
+		if err != nil {
+			// One unreadable file does not hide the others, it is listed with what the manifest knows
+			logger.Warn("Failed to read file info", "file", manifest.Name, "error", err)
+			info = &FileInfo{Size: -1}
+		}
+		entry.ContentType, entry.Size, entry.ModTime = info.ContentType, info.Size, info.ModTime
+		if manifest.Info == nil || entry.Size < 0 {
+			// Files uploaded before the size was recorded, or whose info cannot be read
+			if entry.Size, err = fh.plaintextSize(ctx, manifest); err != nil {
+				logger.Error("Failed to compute file size", "file", manifest.Name, "error", err)
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"message": "Could not list files",
+				})
+				return
+			}
+		}
+		if entry.ModTime.IsZero() {
+			entry.ModTime = manifest.CreatedAt
+		}
+		result = append(result, entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"prefix": prefix,
+		"files":  result,
+	})
+}
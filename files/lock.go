@@ -0,0 +1,87 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"taurus-minio/client"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Parses the optional WORM form fields of an upload.
+// Mode is GOVERNANCE or COMPLIANCE and requires retainUntil as RFC3339 time or date.
+// Returns nil if no protection was requested
+func parseObjectLock(mode string, retainUntil string, legalHold string) (*client.ObjectLock, error) {
+	lock := client.ObjectLock{}
+
+	if legalHold != "" {
+		hold, err := strconv.ParseBool(legalHold)
+		if err != nil {
+			return nil, errors.New("legal-hold must be true or false")
+		}
+		lock.LegalHold = hold
+	}
+
+	if mode != "" || retainUntil != "" {
+		lock.Mode = minio.RetentionMode(strings.ToUpper(mode))
+		if !lock.Mode.IsValid() {
+			return nil, errors.New("retention-mode must be GOVERNANCE or COMPLIANCE")
+		}
+		until, err := time.Parse(time.RFC3339, retainUntil)
+		if err != nil {
+			until, err = time.Parse("2006-01-02", retainUntil)
+		}
+		if err != nil {
+			return nil, errors.New("retain-until must be a date (2006-01-02) or time in RFC3339 format")
+		}
+		if !until.After(time.Now()) {
+			return nil, errors.New("retain-until must be in the future")
+		}
+		lock.RetainUntil = until.UTC()
+	}
+
+	if !lock.IsActive() {
+		return nil, nil
+	}
+	return &lock, nil
+}
+
+// Returned when a file cannot be removed because one of its versions is protected
+type LockedError struct {
+	Name      string
+	VersionID string
+	Lock      *client.ObjectLock
+}
+
+func (err *LockedError) Error() string {
+	return fmt.Sprintf("version %s of %s is %s", err.VersionID, err.Name, err.Lock)
+}
+
+// Checks the lock of every version as stored in minio, as legal holds can be changed after upload.
+// Returns LockedError for the first protected version
+func (fh *FileHandler) checkLocks(ctx context.Context, versions []*Manifest) error {
+	if !fh.minioClient.UseObjectLocking() {
+		return nil
+	}
+	for _, version := range versions {
+		objects := []string{getVersionName(version.Name, version.version())}
+		if len(version.Objects) > 0 {
+			objects = append(objects, version.Objects[0].Name)
+		}
+		for _, object := range objects {
+			lock, err := fh.minioClient.GetObjectLock(ctx, object)
+			if err != nil && !client.IsNotFound(err) {
+				return err
+			}
+			if lock != nil {
+				return &LockedError{Name: version.Name, VersionID: version.version(), Lock: lock}
+			}
+		}
+	}
+	return nil
+}
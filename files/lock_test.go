@@ -0,0 +1,52 @@
+package files
+
+import (
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+func TestParseObjectLock(t *testing.T) {
+	future := time.Now().Add(48 * time.Hour).UTC()
+	tests := []struct {
+		name        string
+		mode        string
+		retainUntil string
+		legalHold   string
+		wantMode    minio.RetentionMode
+		wantHold    bool
+		wantNil     bool
+		wantErr     bool
+	}{
+		{"No lock", "", "", "", "", false, true, false},
+		{"Legal hold off", "", "", "false", "", false, true, false},
+		{"Legal hold", "", "", "true", "", true, false, false},
+		{"Compliance", "compliance", future.Format(time.RFC3339), "", minio.Compliance, false, false, false},
+		{"Governance date", "GOVERNANCE", future.Format("2006-01-02"), "true", minio.Governance, true, false, false},
+		{"Invalid mode", "forever", future.Format(time.RFC3339), "", "", false, false, true},
+		{"Missing date", "COMPLIANCE", "", "", "", false, false, true},
+		{"Past date", "COMPLIANCE", "2000-01-01", "", "", false, false, true},
+		{"Invalid hold", "", "", "maybe", "", false, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lock, err := parseObjectLock(tt.mode, tt.retainUntil, tt.legalHold)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseObjectLock() error = %v, wantErr = %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if (lock == nil) != tt.wantNil {
+				t.Errorf("parseObjectLock() lock = %v, wantNil = %v", lock, tt.wantNil)
+				return
+			}
+			if lock != nil && (lock.Mode != tt.wantMode || lock.LegalHold != tt.wantHold) {
+				t.Errorf("parseObjectLock() lock = %+v, want mode = %s hold = %t", lock, tt.wantMode, tt.wantHold)
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"taurus-minio/client"
+)
+
+// Encrypted contents of every upload are stored under this prefix, one "folder" per upload id
+const DATA_PREFIX = INTERNAL_PREFIX + "data/"
+
+// Manifests of the current version are stored under this prefix with the file name as the rest of the key
+const MANIFEST_PREFIX = INTERNAL_PREFIX + "manifests/"
+
+// Manifests of every version are stored under this prefix as `{file name}/{version id}`
+const VERSION_PREFIX = INTERNAL_PREFIX + "versions/"
+
+// Single stored object that is part of a file
+type ManifestObject struct {
+	Name string `json:"name"`
+	ETag string `json:"etag"`
+	Size int64  `json:"size"`
+}
+
+// Describes which objects make up a file.
+// An upload is only visible once its manifest is written, which is the commit point
+type Manifest struct {
+	Name string `json:"name"`
+	// Version of the file. Empty for manifests written before versioning, use version()
+	VersionID string `json:"versionId,omitempty"`
+	// Upload that stored the objects. Restored versions share objects with the original upload
+	UploadID     string `json:"uploadId"`
+	RestoredFrom string `json:"restoredFrom,omitempty"`
+	Chunked      bool   `json:"chunked"`
+	// Plaintext bytes per encrypted block. Empty for manifests written before it was configurable, use blockSize()
+	BlockSize uint64 `json:"blockSize,omitempty"`
+	// Id of the encryption key. Empty for manifests written before keys could be rotated
+	KeyID string `json:"keyId,omitempty"`
+	// FileInfo encrypted with the key of the file. Empty for files uploaded before it was recorded
+	Info      []byte           `json:"info,omitempty"`
+	Objects   []ManifestObject `json:"objects"`
+	CreatedAt time.Time        `json:"createdAt"`
+	// Retention or legal hold applied to the objects and the version record
+	Lock *client.ObjectLock `json:"lock,omitempty"`
+}
+
+// Helper function that produces the name of the object holding data of the upload
+func getDataName(uploadId string) string {
+	return DATA_PREFIX + uploadId
+}
+
+// Returns upload id of an object under DATA_PREFIX
+func getUploadId(objectName string) string {
+	id := strings.TrimPrefix(objectName, DATA_PREFIX)
+	if matches := chunkNameRegex.FindStringSubmatch(id); matches != nil {
+		id = matches[1]
+	}
+	return id
+}
+
+func getManifestName(filename string) string {
+	return MANIFEST_PREFIX + filename
+}
+
+func getVersionName(filename string, versionId string) string {
+	return VERSION_PREFIX + filename + "/" + versionId
+}
+
+// Version id of the manifest
+func (manifest *Manifest) version() string {
+	if manifest.VersionID == "" {
+		return manifest.UploadID
+	}
+	return manifest.VersionID
+}
+
+// Plaintext bytes per encrypted block of the objects
+func (manifest *Manifest) blockSize() uint64 {
+	if manifest.BlockSize == 0 {
+		return BUFFER_SIZE
+	}
+	return manifest.BlockSize
+}
+
+// Stored size of all objects of the manifest
+func (manifest *Manifest) size() int64 {
+	size := int64(0)
+	for _, object := range manifest.Objects {
+		size += object.Size
+	}
+	return size
+}
+
+// Names of the objects in the order they have to be served
+func (manifest *Manifest) objectNames() []string {
+	names := make([]string, 0, len(manifest.Objects))
+	for _, object := range manifest.Objects {
+		names = append(names, object.Name)
+	}
+	return names
+}
+
+func (fh *FileHandler) putManifest(ctx context.Context, manifest *Manifest, name string, lock *client.ObjectLock) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	_, err = fh.minioClient.PutLockedObject(ctx, bytes.NewReader(data), int64(len(data)), name, "application/json", lock)
+	return err
+}
+
+// Writes the manifest as a new version and makes it the current version of the file.
+// The version is written first, so a failure in between never leaves a current version without history.
+// Only the version record is locked, the current version pointer is replaced by every upload
+func (fh *FileHandler) commitManifest(ctx context.Context, manifest *Manifest) error {
+	defer fh.lockName(manifest.Name)()
+	if err := fh.putManifest(ctx, manifest, getVersionName(manifest.Name, manifest.version()), manifest.Lock); err != nil {
+		return err
+	}
+	return fh.putManifest(ctx, manifest, getManifestName(manifest.Name), nil)
+}
+
+func (fh *FileHandler) readManifestObject(ctx context.Context, name string) (*Manifest, error) {
+	data, err := fh.minioClient.ReadObject(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// Reads the manifest of the current version of the file. Use client.IsNotFound to check for files uploaded before manifests
+func (fh *FileHandler) readManifest(ctx context.Context, filename string) (*Manifest, error) {
+	return fh.readManifestObject(ctx, getManifestName(filename))
+}
+
+// Reads the manifest of a single version of the file
+func (fh *FileHandler) readVersion(ctx context.Context, filename string, versionId string) (*Manifest, error) {
+	manifest, err := fh.readManifestObject(ctx, getVersionName(filename, versionId))
+	if err == nil || !client.IsNotFound(err) {
+		return manifest, err
+	}
+	// Current manifests written before versioning have no version record
+	current, errCurrent := fh.readManifest(ctx, filename)
+	if errCurrent == nil && current.version() == versionId {
+		return current, nil
+	}
+	return nil, err
+}
+
+// Reads manifests of every object under the prefix, skipping the ones removed while listing
+func (fh *FileHandler) readManifestsUnder(ctx context.Context, prefix string) ([]*Manifest, error) {
+	objects, err := fh.minioClient.ListObjects(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	manifests := make([]*Manifest, 0, len(objects))
+	for _, object := range objects {
+		manifest, err := fh.readManifestObject(ctx, object.Key)
+		if err != nil {
+			if client.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		manifests = append(manifests, manifest)
+	}
+	return manifests, nil
+}
+
+// Manifests of the file itself. Listing the versions of docs also lists those of docs/report.pdf
+func versionsOf(manifests []*Manifest, filename string) []*Manifest {
+	versions := make([]*Manifest, 0, len(manifests))
+	for _, manifest := range manifests {
+		if manifest.Name == filename {
+			versions = append(versions, manifest)
+		}
+	}
+	return versions
+}
+
+// Reads every version of the file, newest first
+func (fh *FileHandler) listVersions(ctx context.Context, filename string) ([]*Manifest, error) {
+	nested, err := fh.readManifestsUnder(ctx, VERSION_PREFIX+filename+"/")
+	if err != nil {
+		return nil, err
+	}
+	versions := versionsOf(nested, filename)
+	current, err := fh.readManifest(ctx, filename)
+	if err != nil && !client.IsNotFound(err) {
+		return nil, err
+	}
+	if current != nil {
+		versions = appendVersion(versions, current)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].CreatedAt.After(versions[j].CreatedAt)
+	})
+	return versions, nil
+}
+
+// Appends manifest unless its version is already in the list
+func appendVersion(manifests []*Manifest, manifest *Manifest) []*Manifest {
+	for _, existing := range manifests {
+		if existing.Name == manifest.Name && existing.version() == manifest.version() {
+			return manifests
+		}
+	}
+	return append(manifests, manifest)
+}
+
+// Reads every committed manifest in the bucket, current versions as well as history.
+// Every version is returned once
+func (fh *FileHandler) readAllManifests(ctx context.Context) ([]*Manifest, error) {
+	manifests, err := fh.readManifestsUnder(ctx, VERSION_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+	current, err := fh.readManifestsUnder(ctx, MANIFEST_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for _, manifest := range manifests {
+		seen[manifest.Name+"/"+manifest.version()] = true
+	}
+	for _, manifest := range current {
+		if !seen[manifest.Name+"/"+manifest.version()] {
+			manifests = append(manifests, manifest)
+		}
+	}
+	return manifests, nil
+}
+
+// Lock of a file name, held by one commit, delete or restore of the file at a time
+type nameLock struct {
+	mu sync.Mutex
+	// Holders and waiters, the lock is dropped when none are left
+	users int
+}
+
+// Locks the file name, so its manifests are not changed concurrently. Returns the function that unlocks it
+func (fh *FileHandler) lockName(name string) func() {
+	fh.namesMu.Lock()
+	lock, ok := fh.names[name]
+	if !ok {
+		lock = &nameLock{}
+		fh.names[name] = lock
+	}
+	lock.users++
+	fh.namesMu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+		fh.namesMu.Lock()
+		defer fh.namesMu.Unlock()
+		if lock.users--; lock.users == 0 {
+			delete(fh.names, name)
+		}
+	}
+}
+
+// Marks upload as in progress, so its objects are not collected before the manifest is committed
+func (fh *FileHandler) beginUpload(uploadId string) {
+	fh.uploadsMu.Lock()
+	defer fh.uploadsMu.Unlock()
+	fh.uploads[uploadId] = time.Now()
+}
+
+func (fh *FileHandler) endUpload(uploadId string) {
+	fh.uploadsMu.Lock()
+	defer fh.uploadsMu.Unlock()
+	delete(fh.uploads, uploadId)
+}
+
+func (fh *FileHandler) isUploading(uploadId string) bool {
+	fh.uploadsMu.Lock()
+	defer fh.uploadsMu.Unlock()
+	_, ok := fh.uploads[uploadId]
+	return ok
+}
+
+// Snapshot of the uploads in progress
+func (fh *FileHandler) uploadsInProgress() map[string]bool {
+	fh.uploadsMu.Lock()
+	defer fh.uploadsMu.Unlock()
+	running := make(map[string]bool, len(fh.uploads))
+	for uploadId := range fh.uploads {
+		running[uploadId] = true
+	}
+	return running
+}
+
+// Waits until every running upload has ended or ctx is done.
+// Returns the number of uploads still running
+func (fh *FileHandler) WaitForUploads(ctx context.Context) int {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		fh.uploadsMu.Lock()
+		running := len(fh.uploads)
+		fh.uploadsMu.Unlock()
+		if running == 0 {
+			return 0
+		}
+		select {
+		case <-ctx.Done():
+			return running
+		case <-ticker.C:
+		}
+	}
+}
+
+// Removes the objects stored by an upload that failed or was cancelled before committing its manifest.
+// Whatever is left is removed by the garbage collection
+func (fh *FileHandler) removeUploadData(ctx context.Context, uploadId string) {
+	objects, err := fh.minioClient.ListObjects(ctx, getDataName(uploadId))
+	if err != nil {
+		slog.Warn("Failed to list data of aborted upload", "uploadId", uploadId, "error", err)
+		return
+	}
+	for _, object := range objects {
+		if err := fh.minioClient.RemoveObject(ctx, object.Key); err != nil {
+			slog.Warn("Failed to remove data of aborted upload", "object", object.Key, "error", err)
+		}
+	}
+	if len(objects) > 0 {
+		slog.Info("Removed data of aborted upload", "uploadId", uploadId, "objects", len(objects))
+	}
+}
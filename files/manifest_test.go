@@ -0,0 +1,65 @@
+package files
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVersionsOf(t *testing.T) {
+	// Everything stored under the versions of docs, as returned by the recursive listing
+	listed := []*Manifest{
+		{Name: "docs", VersionID: "first"},
+		{Name: "docs/report.pdf", VersionID: "second"},
+		{Name: "docs", VersionID: "third"},
+		{Name: "docs/archive/old.txt", VersionID: "fourth"},
+	}
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"docs", []string{"first", "third"}},
+		{"docs/report.pdf", []string{"second"}},
+		{"docs/archive", nil},
+	}
+	for _, tt := range tests {
+		got := versionsOf(listed, tt.name)
+		if len(got) != len(tt.want) {
+			t.Fatalf("versionsOf(%q) returned %d versions, want %d", tt.name, len(got), len(tt.want))
+		}
+		for i, manifest := range got {
+			if manifest.version() != tt.want[i] {
+				t.Errorf("versionsOf(%q)[%d] = %s, want %s", tt.name, i, manifest.version(), tt.want[i])
+			}
+		}
+	}
+}
+
+func TestLockName(t *testing.T) {
+	fh := testFileHandler()
+	fh.names = make(map[string]*nameLock)
+	unlock := fh.lockName("docs")
+	// Other names are not held up
+	fh.lockName("other")()
+
+	locked := make(chan struct{})
+	go func() {
+		fh.lockName("docs")()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("lockName() of a locked name returned")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("lockName() did not return after the name was unlocked")
+	}
+	fh.namesMu.Lock()
+	defer fh.namesMu.Unlock()
+	if len(fh.names) != 0 {
+		t.Errorf("%d name locks left after unlocking, want none", len(fh.names))
+	}
+}
@@ -0,0 +1,102 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"taurus-minio/client"
+	"taurus-minio/encryption"
+)
+
+// Returned by ReadFile if the file or version does not exist
+var ErrFileNotFound = errors.New("file not found")
+
+// Opens a stored object by its name in the bucket, e.g. from minio or from a local copy of the bucket
+type ObjectOpener func(ctx context.Context, name string) (io.ReadCloser, error)
+
+// Encrypts plaintext into the format of a stored object: the file id followed by the encrypted blocks.
+// Lets files be stored without the server, e.g. by the taurus command
+func EncryptObject(ctx context.Context, plaintext io.Reader, object io.Writer, cryptographer *encryption.Cryptographer, blockSize uint64) error {
+	r, w := io.Pipe()
+	defer r.Close()
+	go readEncryptWrite(ctx, plaintext, w, cryptographer, blockSize)
+	_, err := io.Copy(object, r)
+	return err
+}
+
+// Decrypts a stored object with whichever of keys encrypted it
+func DecryptObject(ctx context.Context, object io.Reader, plaintext io.Writer, keys []*encryption.Cryptographer, blockSize uint64) error {
+	r, w := io.Pipe()
+	defer r.Close()
+	go readDecryptWrite(ctx, object, w, keys, blockSize)
+	_, err := io.Copy(plaintext, r)
+	return err
+}
+
+// Decrypts the objects of the manifest in order, which reassembles chunked files.
+// The key recorded in the manifest is taken from keyring, older manifests try every key
+func DecryptManifest(ctx context.Context, manifest *Manifest, keyring *encryption.Keyring, open ObjectOpener, plaintext io.Writer) error {
+	keys := keysOf(keyring, manifest.KeyID)
+	if len(keys) == 0 {
+		return fmt.Errorf("encryption key %s of the file is not configured", manifest.KeyID)
+	}
+	for _, name := range manifest.objectNames() {
+		object, err := open(ctx, name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		err = DecryptObject(ctx, object, plaintext, keys, manifest.blockSize())
+		object.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Writes the plaintext of the file to w, an empty version reads the current one.
+// Unlike the download handler the objects are read one after another
+func (fh *FileHandler) ReadFile(ctx context.Context, name string, version string, w io.Writer) error {
+	manifest, err := fh.findManifest(ctx, name, version)
+	if err != nil {
+		return err
+	}
+	open := func(ctx context.Context, name string) (io.ReadCloser, error) {
+		return fh.minioClient.DownloadFile(ctx, name)
+	}
+	return DecryptManifest(ctx, manifest, fh.keyring.Load(), open, w)
+}
+
+// Manifest of the file, made up for files uploaded before manifests were introduced
+func (fh *FileHandler) findManifest(ctx context.Context, name string, version string) (*Manifest, error) {
+	var manifest *Manifest
+	var err error
+	if version != "" {
+		manifest, err = fh.readVersion(ctx, name, version)
+	} else {
+		manifest, err = fh.readManifest(ctx, name)
+	}
+	if err == nil {
+		return manifest, nil
+	}
+	if !client.IsNotFound(err) {
+		return nil, err
+	}
+	if version != "" {
+		return nil, ErrFileNotFound
+	}
+	chunked, objects, err := fh.legacyObjects(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, ErrFileNotFound
+	}
+	manifest = &Manifest{Name: name, Chunked: chunked}
+	for _, object := range objects {
+		manifest.Objects = append(manifest.Objects, ManifestObject{Name: object})
+	}
+	return manifest, nil
+}
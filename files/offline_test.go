@@ -0,0 +1,44 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecryptManifest(t *testing.T) {
+	fh := testFileHandler()
+	keyring := fh.keyring.Load()
+	chunks := []string{strings.Repeat("a", 3000), strings.Repeat("b", 1500)}
+	stored := make(map[string][]byte)
+	manifest := &Manifest{Chunked: true, BlockSize: 1024, KeyID: keyring.Active().KeyID()}
+	for i, chunk := range chunks {
+		var object bytes.Buffer
+		// Plaintext arriving in small pieces must still be encrypted in full blocks
+		if err := EncryptObject(context.Background(), iotest.HalfReader(strings.NewReader(chunk)), &object, keyring.Active(), manifest.BlockSize); err != nil {
+			t.Fatalf("EncryptObject() error = %v", err)
+		}
+		name := getChunkName(getDataName("upload"), uint64(i))
+		stored[name] = object.Bytes()
+		manifest.Objects = append(manifest.Objects, ManifestObject{Name: name})
+	}
+	open := func(ctx context.Context, name string) (io.ReadCloser, error) {
+		return io.NopCloser(iotest.OneByteReader(bytes.NewReader(stored[name]))), nil
+	}
+
+	var plaintext bytes.Buffer
+	if err := DecryptManifest(context.Background(), manifest, keyring, open, &plaintext); err != nil {
+		t.Fatalf("DecryptManifest() error = %v", err)
+	}
+	if plaintext.String() != strings.Join(chunks, "") {
+		t.Errorf("DecryptManifest() returned %d bytes, want the %d bytes of the chunks", plaintext.Len(), len(chunks[0])+len(chunks[1]))
+	}
+
+	manifest.KeyID = "unknown"
+	if err := DecryptManifest(context.Background(), manifest, keyring, open, io.Discard); err == nil {
+		t.Error("DecryptManifest() with a key that is not configured should fail")
+	}
+}
@@ -0,0 +1,75 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Range header that cannot be served, e.g. with several ranges. The whole file is sent instead
+var errInvalidRange = errors.New("invalid range")
+
+// Range starting after the end of the file, answered with 416
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// Offset and length of a single byte range of the Range header, e.g. bytes=0-499, bytes=500- or bytes=-500.
+// The end of the range is cut to the size of the file
+func parseRange(header string, size int64) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, errInvalidRange
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, errInvalidRange
+	}
+	if first == "" {
+		// Suffix of the file
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix < 0 {
+			return 0, 0, errInvalidRange
+		}
+		if suffix == 0 || size == 0 {
+			return 0, 0, errRangeNotSatisfiable
+		}
+		suffix = min(suffix, size)
+		return size - suffix, suffix, nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, errInvalidRange
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, errInvalidRange
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return 0, 0, errRangeNotSatisfiable
+	}
+	return start, end - start + 1, nil
+}
+
+// Drops the chunks that end before offset from the manifest.
+// Returns the manifest of the remaining chunks and the plaintext bytes to skip in them
+func (fh *FileHandler) skipObjects(ctx context.Context, manifest *Manifest, offset int64) (*Manifest, int64, error) {
+	if offset == 0 || !manifest.Chunked {
+		return manifest, offset, nil
+	}
+	sizes, err := fh.objectSizes(ctx, manifest)
+	if err != nil {
+		return nil, 0, err
+	}
+	skipped := 0
+	// The last chunk is always kept, so the reader still ends with the file
+	for skipped < len(sizes)-1 && offset >= sizes[skipped] {
+		offset -= sizes[skipped]
+		skipped++
+	}
+	rest := *manifest
+	rest.Objects = manifest.Objects[skipped:]
+	return &rest, offset, nil
+}
@@ -0,0 +1,65 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header string
+		size   int64
+		start  int64
+		length int64
+		err    error
+	}{
+		{"bytes=0-499", 1000, 0, 500, nil},
+		{"bytes=500-", 1000, 500, 500, nil},
+		{"bytes=-300", 1000, 700, 300, nil},
+		{"bytes=900-2000", 1000, 900, 100, nil},
+		{"bytes=-2000", 1000, 0, 1000, nil},
+		{"bytes=999-999", 1000, 999, 1, nil},
+		{"bytes=1000-", 1000, 0, 0, errRangeNotSatisfiable},
+		{"bytes=0-", 0, 0, 0, errRangeNotSatisfiable},
+		{"bytes=-0", 1000, 0, 0, errRangeNotSatisfiable},
+		{"bytes=0-1,5-6", 1000, 0, 0, errInvalidRange},
+		{"bytes=5-3", 1000, 0, 0, errInvalidRange},
+		{"items=0-1", 1000, 0, 0, errInvalidRange},
+		{"bytes=a-", 1000, 0, 0, errInvalidRange},
+	}
+	for _, tt := range tests {
+		start, length, err := parseRange(tt.header, tt.size)
+		if !errors.Is(err, tt.err) || (err == nil && (start != tt.start || length != tt.length)) {
+			t.Errorf("parseRange(%q, %d) = %d, %d, %v, want %d, %d, %v", tt.header, tt.size, start, length, err, tt.start, tt.length, tt.err)
+		}
+	}
+}
+
+func TestSkipObjects(t *testing.T) {
+	fh := testFileHandler()
+	// Three chunks of 2048, 2048 and 100 plaintext bytes in blocks of 1024
+	manifest := &Manifest{Chunked: true, BlockSize: 1024, Objects: []ManifestObject{
+		{Name: "chunk0", Size: 16 + 2*(1024+28)},
+		{Name: "chunk1", Size: 16 + 2*(1024+28)},
+		{Name: "chunk2", Size: 16 + 100 + 28},
+	}}
+	tests := []struct {
+		offset int64
+		first  string
+		skip   int64
+	}{
+		{0, "chunk0", 0},
+		{2047, "chunk0", 2047},
+		{2048, "chunk1", 0},
+		{4100, "chunk2", 4},
+		// The last chunk is kept, reading past its end fails on the skipped bytes
+		{5000, "chunk2", 904},
+	}
+	for _, tt := range tests {
+		rest, skip, err := fh.skipObjects(context.Background(), manifest, tt.offset)
+		if err != nil || rest.Objects[0].Name != tt.first || skip != tt.skip {
+			t.Errorf("skipObjects(%d) starts at %s skipping %d, %v, want %s skipping %d", tt.offset, rest.Objects[0].Name, skip, err, tt.first, tt.skip)
+		}
+	}
+}
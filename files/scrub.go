@@ -0,0 +1,470 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"taurus-minio/client"
+	"taurus-minio/encryption"
+
+	"github.com/gin-gonic/gin"
+	"github.com/minio/minio-go/v7"
+	"golang.org/x/time/rate"
+)
+
+// Objects under this prefix are created by the application itself and are never served as files
+const INTERNAL_PREFIX = ".taurus/"
+
+// Where the scrubber stores its JSON reports
+const SCRUB_REPORT_PREFIX = INTERNAL_PREFIX + "fsck/"
+
+var chunkNameRegex = regexp.MustCompile(`^(.+)_chunk([0-9]+)$`)
+
+// Single problem found by the scrubber
+type ScrubEntry struct {
+	File    string   `json:"file"`
+	Objects []string `json:"objects,omitempty"`
+	Reason  string   `json:"reason"`
+}
+
+// Result of a full bucket scrub
+type ScrubReport struct {
+	StartedAt  time.Time    `json:"startedAt"`
+	FinishedAt time.Time    `json:"finishedAt"`
+	Objects    int          `json:"objects"`
+	Bytes      int64        `json:"bytes"`
+	Corrupt    []ScrubEntry `json:"corrupt"`
+	Orphaned   []ScrubEntry `json:"orphaned"`
+	Incomplete []ScrubEntry `json:"incomplete"`
+	Error      string       `json:"error,omitempty"`
+}
+
+// Walks the bucket and verifies every GCM tag of every object and chunk.
+// Runs periodically in the background and on demand from the admin endpoint
+type Scrubber struct {
+	fh            *FileHandler
+	configuration client.ScrubberConfiguration
+
+	mu         sync.Mutex
+	running    bool
+	lastReport *ScrubReport
+	// Limiter of the current or last scrub, changed when the configuration is reloaded
+	limiter *rate.Limiter
+}
+
+// Creates scrubber for the files handled by the given file handler
+func InitScrubber(fh *FileHandler, configuration client.ScrubberConfiguration) *Scrubber {
+	return &Scrubber{
+		fh:            fh,
+		configuration: configuration,
+	}
+}
+
+// Starts the periodic background scrub if it is enabled in the configuration
+func (scrubber *Scrubber) Start() {
+	if !scrubber.configuration.Enabled || scrubber.configuration.Interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(scrubber.configuration.Interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if _, err := scrubber.Run(context.Background()); err != nil {
+				slog.Warn("Scheduled scrub skipped", "error", err)
+			}
+		}
+	}()
+}
+
+var errScrubRunning = errors.New("scrub is already running")
+
+// Performs a single scrub of the whole bucket and stores the report.
+// Only one scrub can run at a time
+func (scrubber *Scrubber) Run(ctx context.Context) (*ScrubReport, error) {
+	scrubber.mu.Lock()
+	if scrubber.running {
+		scrubber.mu.Unlock()
+		return nil, errScrubRunning
+	}
+	scrubber.running = true
+	scrubber.mu.Unlock()
+
+	report := scrubber.scrub(ctx)
+
+	scrubber.mu.Lock()
+	scrubber.running = false
+	scrubber.lastReport = report
+	scrubber.mu.Unlock()
+
+	scrubber.saveReport(ctx, report)
+	return report, nil
+}
+
+func (scrubber *Scrubber) scrub(ctx context.Context) *ScrubReport {
+	report := &ScrubReport{
+		StartedAt:  time.Now().UTC(),
+		Corrupt:    []ScrubEntry{},
+		Orphaned:   []ScrubEntry{},
+		Incomplete: []ScrubEntry{},
+	}
+	slog.Info("Starting scrub of the bucket")
+
+	objects, err := scrubber.fh.minioClient.ListObjects(ctx, "")
+	if err != nil {
+		report.Error = err.Error()
+		report.FinishedAt = time.Now().UTC()
+		return report
+	}
+
+	// Encrypted data lives either under DATA_PREFIX or, for files uploaded
+	// before manifests were introduced, directly under the file name
+	keys := make([]string, 0, len(objects))
+	legacyKeys := make([]string, 0)
+	dataKeys := make([]string, 0)
+	for _, object := range objects {
+		if strings.HasPrefix(object.Key, DATA_PREFIX) {
+			dataKeys = append(dataKeys, object.Key)
+		} else if strings.HasPrefix(object.Key, INTERNAL_PREFIX) {
+			continue
+		} else {
+			legacyKeys = append(legacyKeys, object.Key)
+		}
+		keys = append(keys, object.Key)
+	}
+
+	orphaned, incomplete := checkChunkSequences(legacyKeys)
+	report.Orphaned = append(report.Orphaned, orphaned...)
+	report.Incomplete = append(report.Incomplete, incomplete...)
+
+	manifests, err := scrubber.fh.readReferencedManifests(ctx)
+	if err != nil {
+		report.Error = err.Error()
+		report.FinishedAt = time.Now().UTC()
+		return report
+	}
+	orphaned, incomplete = checkManifests(manifests, dataKeys, scrubber.fh.isUploading)
+	report.Orphaned = append(report.Orphaned, orphaned...)
+	report.Incomplete = append(report.Incomplete, incomplete...)
+
+	// Report corrupt data objects under the name of the file they belong to
+	files := make(map[string]string)
+	blockSizes := make(map[string]uint64)
+	keyIds := make(map[string]string)
+	for _, manifest := range manifests {
+		for _, object := range manifest.Objects {
+			files[object.Name] = manifest.Name
+			blockSizes[object.Name] = manifest.blockSize()
+			keyIds[object.Name] = manifest.KeyID
+		}
+	}
+
+	limiter := scrubber.newLimiter()
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			report.Error = ctx.Err().Error()
+			break
+		}
+		// Unreferenced and legacy objects are checked with the default block size and every key
+		blockSize, ok := blockSizes[key]
+		if !ok {
+			blockSize = BUFFER_SIZE
+		}
+		report.Objects++
+		keys := scrubber.fh.keysFor(keyIds[key])
+		var err error
+		if len(keys) == 0 {
+			err = errors.New("encryption key is not configured")
+		} else {
+			var object *minio.Object
+			if object, err = scrubber.fh.minioClient.DownloadFile(ctx, key); err == nil {
+				var n int64
+				n, err = scrubber.fh.verifyObject(&limitedReader{ctx: ctx, reader: object, limiter: limiter}, keys, blockSize)
+				object.Close()
+				report.Bytes += n
+			}
+		}
+		if err != nil {
+			file, ok := files[key]
+			if !ok {
+				file = key
+				if matches := chunkNameRegex.FindStringSubmatch(key); matches != nil {
+					file = matches[1]
+				}
+			}
+			report.Corrupt = append(report.Corrupt, ScrubEntry{File: file, Objects: []string{key}, Reason: err.Error()})
+		}
+	}
+
+	report.FinishedAt = time.Now().UTC()
+	slog.Info("Finished scrub", "objects", report.Objects, "corrupt", len(report.Corrupt),
+		"orphaned", len(report.Orphaned), "incomplete", len(report.Incomplete))
+	return report
+}
+
+// Stores the report as JSON next to the data so it survives restarts
+func (scrubber *Scrubber) saveReport(ctx context.Context, report *ScrubReport) {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		slog.Error("Failed to encode scrub report", "error", err)
+		return
+	}
+	name := SCRUB_REPORT_PREFIX + report.StartedAt.Format("20060102T150405Z") + ".json"
+	_, err = scrubber.fh.minioClient.PutObject(ctx, bytes.NewReader(data), int64(len(data)), name, "application/json")
+	if err != nil {
+		slog.Error("Failed to store scrub report", "report", name, "error", err)
+	}
+}
+
+// Limiter shared by all reads of a single scrub
+func (scrubber *Scrubber) newLimiter() *rate.Limiter {
+	limiter := rate.NewLimiter(rate.Inf, 0)
+	scrubber.mu.Lock()
+	defer scrubber.mu.Unlock()
+	scrubber.limiter = limiter
+	setReadRate(limiter, scrubber.configuration.BytesPerSecond)
+	return limiter
+}
+
+// Changes the read limit, also of a running scrub. 0 means unlimited
+func (scrubber *Scrubber) SetBytesPerSecond(bytesPerSecond int) {
+	scrubber.mu.Lock()
+	defer scrubber.mu.Unlock()
+	scrubber.configuration.BytesPerSecond = bytesPerSecond
+	if scrubber.limiter != nil {
+		setReadRate(scrubber.limiter, bytesPerSecond)
+	}
+}
+
+func setReadRate(limiter *rate.Limiter, bytesPerSecond int) {
+	if bytesPerSecond <= 0 {
+		limiter.SetLimit(rate.Inf)
+		return
+	}
+	// Burst must fit at least one encrypted block, otherwise WaitN fails
+	burst := bytesPerSecond
+	if burst < int(BUFFER_SIZE)+int(getEncryptionOverhead()) {
+		burst = int(BUFFER_SIZE) + int(getEncryptionOverhead())
+	}
+	limiter.SetBurst(burst)
+	limiter.SetLimit(rate.Limit(bytesPerSecond))
+}
+
+// Groups chunk objects by file and finds gaps in the chunk numbering (incomplete files)
+// and chunks stored alongside a whole object of the same name (orphaned chunks)
+func checkChunkSequences(keys []string) (orphaned []ScrubEntry, incomplete []ScrubEntry) {
+	whole := make(map[string]bool)
+	chunks := make(map[string]map[uint64]string)
+	for _, key := range keys {
+		matches := chunkNameRegex.FindStringSubmatch(key)
+		if matches == nil {
+			whole[key] = true
+			continue
+		}
+		id, err := strconv.ParseUint(matches[2], 10, 64)
+		if err != nil || getChunkName(matches[1], id) != key {
+			// e.g. leading zeros, can never be produced by the upload
+			orphaned = append(orphaned, ScrubEntry{File: matches[1], Objects: []string{key}, Reason: "chunk name is not produced by upload"})
+			continue
+		}
+		if chunks[matches[1]] == nil {
+			chunks[matches[1]] = make(map[uint64]string)
+		}
+		chunks[matches[1]][id] = key
+	}
+
+	names := make([]string, 0, len(chunks))
+	for name := range chunks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		ids := chunks[name]
+		objects := make([]string, 0, len(ids))
+		maxId := uint64(0)
+		for id, key := range ids {
+			objects = append(objects, key)
+			if id > maxId {
+				maxId = id
+			}
+		}
+		sort.Strings(objects)
+
+		if whole[name] {
+			orphaned = append(orphaned, ScrubEntry{File: name, Objects: objects, Reason: "chunks exist next to a whole object of the same name"})
+		}
+
+		missing := make([]string, 0)
+		for id := uint64(0); id <= maxId; id++ {
+			if _, ok := ids[id]; !ok {
+				missing = append(missing, getChunkName(name, id))
+			}
+		}
+		if len(missing) > 0 {
+			incomplete = append(incomplete, ScrubEntry{File: name, Objects: missing, Reason: fmt.Sprintf("%d chunks missing", len(missing))})
+		}
+	}
+	return orphaned, incomplete
+}
+
+// Compares committed manifests against the stored data objects.
+// Files with objects missing from the bucket are incomplete, data objects
+// of uploads that are neither committed nor in progress are orphaned
+func checkManifests(manifests []*Manifest, dataKeys []string, isUploading func(string) bool) (orphaned []ScrubEntry, incomplete []ScrubEntry) {
+	stored := make(map[string]bool)
+	for _, key := range dataKeys {
+		stored[key] = true
+	}
+
+	referenced := make(map[string]bool)
+	for _, manifest := range manifests {
+		missing := make([]string, 0)
+		for _, object := range manifest.Objects {
+			referenced[object.Name] = true
+			if !stored[object.Name] {
+				missing = append(missing, object.Name)
+			}
+		}
+		if len(missing) > 0 {
+			incomplete = append(incomplete, ScrubEntry{File: manifest.Name, Objects: missing, Reason: fmt.Sprintf("%d objects of upload %s missing", len(missing), manifest.UploadID)})
+		}
+	}
+
+	uploads := make(map[string][]string)
+	for _, key := range dataKeys {
+		if referenced[key] {
+			continue
+		}
+		uploadId := getUploadId(key)
+		if isUploading(uploadId) {
+			continue
+		}
+		uploads[uploadId] = append(uploads[uploadId], key)
+	}
+	ids := make([]string, 0, len(uploads))
+	for id := range uploads {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		orphaned = append(orphaned, ScrubEntry{File: id, Objects: uploads[id], Reason: "objects are not referenced by any manifest"})
+	}
+	return orphaned, incomplete
+}
+
+// Reads the encrypted object and verifies the GCM tag of every block of blockSize plaintext bytes.
+// The object must be encrypted with one of keys
+// Returns the number of bytes read and the first integrity error
+func (fh *FileHandler) verifyObject(reader io.Reader, keys []*encryption.Cryptographer, blockSize uint64) (int64, error) {
+	fileId := make([]byte, 16)
+	n, err := io.ReadFull(reader, fileId)
+	total := int64(n)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return total, errors.New("object is shorter than file id")
+		}
+		return total, err
+	}
+
+	outBuf := make([]byte, blockSize+getEncryptionOverhead())
+	blockId := uint64(0)
+	var cryptographer *encryption.Cryptographer
+	for {
+		n, err := io.ReadFull(reader, outBuf)
+		total += int64(n)
+		if err == io.EOF {
+			return total, nil
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return total, err
+		}
+		if blockId == 0 {
+			cryptographer = matchKey(keys, outBuf[:n], fileId)
+		}
+		if _, errDecrypt := cryptographer.TryDecrypt(outBuf[:n], fileId, blockId); errDecrypt != nil {
+			return total, fmt.Errorf("block %d: %w", blockId, errDecrypt)
+		}
+		if err == io.ErrUnexpectedEOF {
+			// Last block is the only one allowed to be short
+			return total, nil
+		}
+		blockId++
+	}
+}
+
+// Reader that waits for the rate limiter before handing out data
+type limitedReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *rate.Limiter
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.limiter == nil || l.limiter.Limit() == rate.Inf {
+		return l.reader.Read(p)
+	}
+	if len(p) > l.limiter.Burst() {
+		p = p[:l.limiter.Burst()]
+	}
+	n, err := l.reader.Read(p)
+	if n > 0 {
+		if errWait := l.limiter.WaitN(l.ctx, n); errWait != nil {
+			return n, errWait
+		}
+	}
+	return n, err
+}
+
+// Admin handler that starts an on-demand scrub in the background
+func (scrubber *Scrubber) StartScrubHandler(c *gin.Context) {
+	scrubber.mu.Lock()
+	running := scrubber.running
+	scrubber.mu.Unlock()
+	if running {
+		c.JSON(http.StatusConflict, gin.H{
+			"message": errScrubRunning.Error(),
+		})
+		return
+	}
+
+	go func() {
+		if _, err := scrubber.Run(context.Background()); err != nil {
+			slog.Warn("On-demand scrub skipped", "error", err)
+		}
+	}()
+	c.JSON(http.StatusAccepted, gin.H{
+		"status": "scrub started",
+	})
+}
+
+// Admin handler that returns the report of the last finished scrub
+func (scrubber *Scrubber) GetScrubReportHandler(c *gin.Context) {
+	scrubber.mu.Lock()
+	running := scrubber.running
+	report := scrubber.lastReport
+	scrubber.mu.Unlock()
+
+	if report == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "No scrub has finished yet",
+			"running": running,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"running": running,
+		"report":  report,
+	})
+}
@@ -0,0 +1,126 @@
+package files
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"taurus-minio/encryption"
+	"taurus-minio/logging"
+	"taurus-minio/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Validity of share links if the request sets none
+const DEFAULT_SHARE_DURATION = 24 * time.Hour
+
+// Longest validity of a share link. Links cannot be revoked, only the keys that sealed them can be removed
+const MAX_SHARE_DURATION = 7 * 24 * time.Hour
+
+// Additional data of sealed share tokens, keeps them apart from other sealed blocks
+var shareId = []byte("share")
+
+// Returned for tokens that were not sealed by a configured key, are damaged or expired
+var errInvalidShare = errors.New("invalid or expired share link")
+
+// Version of a file readable by anyone holding the token until it expires
+type share struct {
+	Name      string    `json:"name"`
+	VersionID string    `json:"versionId,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Encrypts the share with the key of new uploads. The token reveals neither the name nor the expiry
+func sealShare(share *share, cryptographer *encryption.Cryptographer) (string, error) {
+	plaintext, err := json.Marshal(share)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(cryptographer.Encrypt(plaintext, 0, shareId)), nil
+}
+
+// Opens a token sealed with any of keys, tokens of keys removed from the configuration stop working
+func openShare(token string, keys []*encryption.Cryptographer, now time.Time) (*share, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errInvalidShare
+	}
+	for _, cryptographer := range keys {
+		plaintext, err := cryptographer.TryDecrypt(sealed, shareId, 0)
+		if err != nil {
+			continue
+		}
+		var opened share
+		if err := json.Unmarshal(plaintext, &opened); err != nil || !now.Before(opened.ExpiresAt) {
+			return nil, errInvalidShare
+		}
+		return &opened, nil
+	}
+	return nil, errInvalidShare
+}
+
+// Creates a link to file/`name`, pinned to its current version or the `version` query parameter.
+// The link is valid for the `expires` query parameter, e.g. 1h, at most MAX_SHARE_DURATION
+func (fh *FileHandler) ShareFileHandler(c *gin.Context) {
+	name := c.Param("name")
+	version := c.Query("version")
+	ctx := c.Request.Context()
+
+	duration := DEFAULT_SHARE_DURATION
+	if value := c.Query("expires"); value != "" {
+		var err error
+		duration, err = time.ParseDuration(value)
+		if err != nil || duration <= 0 || duration > MAX_SHARE_DURATION {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "expires must be a duration between 1s and " + MAX_SHARE_DURATION.String(),
+			})
+			return
+		}
+	}
+
+	manifest, err := fh.findManifest(ctx, name, version)
+	if errors.Is(err, ErrFileNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Could not find file",
+		})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Could not read file manifest",
+		})
+		return
+	}
+
+	// Later uploads do not change what the link serves
+	shared := &share{Name: name, VersionID: manifest.version(), ExpiresAt: time.Now().UTC().Add(duration).Truncate(time.Second)}
+	token, err := sealShare(shared, fh.activeKey())
+	if err != nil {
+		logging.FromContext(ctx).Error("Failed to seal share link", "file", name, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Could not share file",
+		})
+		return
+	}
+	logging.FromContext(ctx).Info("Shared file", "file", name, "version", shared.VersionID, "expiresAt", shared.ExpiresAt)
+	c.JSON(http.StatusOK, gin.H{
+		"path":      "/shared/" + token,
+		"versionId": shared.VersionID,
+		"expiresAt": shared.ExpiresAt,
+	})
+}
+
+// Downloads the file of a share link on shared/`token`, like file/`name` including ranges and `inline=true`
+func (fh *FileHandler) GetSharedFileHandler(c *gin.Context) {
+	defer func() { metrics.CountDownload(c.Writer.Status()) }()
+	shared, err := openShare(c.Param("token"), fh.keyring.Load().All(), time.Now())
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Invalid or expired share link",
+		})
+		return
+	}
+	fh.serveFile(c, shared.Name, shared.VersionID)
+}
@@ -0,0 +1,43 @@
+package files
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"taurus-minio/encryption"
+)
+
+func TestShareToken(t *testing.T) {
+	fh := testFileHandler()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	shared := &share{Name: "docs/report.pdf", VersionID: "v1", ExpiresAt: now.Add(time.Hour)}
+	token, err := sealShare(shared, fh.activeKey())
+	if err != nil {
+		t.Fatalf("sealShare() error = %v", err)
+	}
+
+	opened, err := openShare(token, fh.keyring.Load().All(), now)
+	if err != nil || *opened != *shared {
+		t.Errorf("openShare() = %+v, %v, want %+v", opened, err, shared)
+	}
+	if _, err := openShare(token, fh.keyring.Load().All(), now.Add(time.Hour)); !errors.Is(err, errInvalidShare) {
+		t.Errorf("openShare() of an expired link error = %v, want errInvalidShare", err)
+	}
+	tampered := []byte(token)
+	tampered[len(tampered)/2] ^= 1
+	if _, err := openShare(string(tampered), fh.keyring.Load().All(), now); !errors.Is(err, errInvalidShare) {
+		t.Errorf("openShare() of a tampered link error = %v, want errInvalidShare", err)
+	}
+
+	// Links stay valid while their key is kept as previous key
+	otherKey, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	oldKey, _ := hex.DecodeString("6368616e676520746869732070617373776f726420746f206120736563726574")
+	if _, err := openShare(token, encryption.InitKeyring(otherKey, oldKey).All(), now); err != nil {
+		t.Errorf("openShare() after a key rotation error = %v", err)
+	}
+	if _, err := openShare(token, encryption.InitKeyring(otherKey).All(), now); !errors.Is(err, errInvalidShare) {
+		t.Errorf("openShare() with the key removed error = %v, want errInvalidShare", err)
+	}
+}
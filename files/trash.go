@@ -0,0 +1,402 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"taurus-minio/client"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/xid"
+)
+
+// Deleted files are stored as a single record under this prefix, by trash id
+const TRASH_PREFIX = INTERNAL_PREFIX + "trash/"
+
+// Deleted file with all of its versions.
+// The data objects stay where they are until the entry is purged
+type TrashEntry struct {
+	ID             string      `json:"id"`
+	Name           string      `json:"name"`
+	DeletedAt      time.Time   `json:"deletedAt"`
+	CurrentVersion string      `json:"currentVersion"`
+	Versions       []*Manifest `json:"versions"`
+}
+
+// Soft delete of files. Deleted files can be restored until the retention period is over,
+// afterwards their data is removed by a background job
+type Trash struct {
+	fh            *FileHandler
+	configuration client.TrashConfiguration
+
+	// Serializes deletes, restores and purges
+	mu sync.Mutex
+}
+
+// Creates trash for the files handled by the given file handler
+func InitTrash(fh *FileHandler, configuration client.TrashConfiguration) *Trash {
+	return &Trash{
+		fh:            fh,
+		configuration: configuration,
+	}
+}
+
+func getTrashName(trashId string) string {
+	return TRASH_PREFIX + trashId
+}
+
+// Starts the background job purging expired entries
+func (trash *Trash) Start() {
+	if trash.configuration.PurgeInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(trash.configuration.PurgeInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := trash.Purge(context.Background()); err != nil {
+				slog.Error("Purging trash failed", "error", err)
+			}
+		}
+	}()
+}
+
+func (fh *FileHandler) readTrashEntry(ctx context.Context, trashId string) (*TrashEntry, error) {
+	data, err := fh.minioClient.ReadObject(ctx, getTrashName(trashId))
+	if err != nil {
+		return nil, err
+	}
+	var entry TrashEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Reads every entry of the trash, oldest deletes first
+func (fh *FileHandler) readTrash(ctx context.Context) ([]*TrashEntry, error) {
+	objects, err := fh.minioClient.ListObjects(ctx, TRASH_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*TrashEntry, 0, len(objects))
+	for _, object := range objects {
+		entry, err := fh.readTrashEntry(ctx, strings.TrimPrefix(object.Key, TRASH_PREFIX))
+		if err != nil {
+			if client.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DeletedAt.Before(entries[j].DeletedAt)
+	})
+	return entries, nil
+}
+
+// Manifests of live files and of files in the trash.
+// Data referenced by any of them must never be removed
+func (fh *FileHandler) readReferencedManifests(ctx context.Context) ([]*Manifest, error) {
+	manifests, err := fh.readAllManifests(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := fh.readTrash(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		manifests = append(manifests, entry.Versions...)
+	}
+	return manifests, nil
+}
+
+// Moves objects of a file uploaded before manifests were introduced under DATA_PREFIX,
+// so the file can be handled like any other. Returns nil if the file does not exist
+func (fh *FileHandler) adoptLegacyFile(ctx context.Context, name string) (*Manifest, []string, error) {
+	chunked, objects, err := fh.legacyObjects(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(objects) == 0 {
+		return nil, nil, nil
+	}
+
+	uploadId := xid.New().String()
+	manifest := &Manifest{
+		Name:      name,
+		VersionID: uploadId,
+		UploadID:  uploadId,
+		Chunked:   chunked,
+		CreatedAt: time.Now().UTC(),
+	}
+	fh.beginUpload(uploadId)
+	defer fh.endUpload(uploadId)
+	for i, object := range objects {
+		destination := getDataName(uploadId)
+		if chunked {
+			destination = getChunkName(destination, uint64(i))
+		}
+		info, err := fh.minioClient.CopyObject(ctx, object, destination)
+		if err != nil {
+			return nil, nil, err
+		}
+		manifest.Objects = append(manifest.Objects, ManifestObject{Name: destination, ETag: info.ETag, Size: info.Size})
+	}
+	return manifest, objects, nil
+}
+
+// Moves the file with all its versions to the trash. Returns nil if the file does not exist
+// and LockedError if any version is under retention or legal hold.
+// Uploads of the file wait with their commit until it is moved, so no version is left behind
+func (trash *Trash) Delete(ctx context.Context, name string) (*TrashEntry, error) {
+	trash.mu.Lock()
+	defer trash.mu.Unlock()
+	defer trash.fh.lockName(name)()
+
+	versions, err := trash.fh.listVersions(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	// The data is referenced by neither the manifests nor the trash entry while they are swapped,
+	// marking the uploads keeps the garbage collector away until the file is moved
+	for _, version := range versions {
+		trash.fh.beginUpload(version.UploadID)
+		defer trash.fh.endUpload(version.UploadID)
+	}
+	current, err := trash.fh.readManifest(ctx, name)
+	if err != nil && !client.IsNotFound(err) {
+		return nil, err
+	}
+
+	// Protected data must stay where it is
+	if err := trash.fh.checkLocks(ctx, versions); err != nil {
+		return nil, err
+	}
+
+	var legacyObjects []string
+	if len(versions) == 0 {
+		var adopted *Manifest
+		adopted, legacyObjects, err = trash.fh.adoptLegacyFile(ctx, name)
+		if err != nil || adopted == nil {
+			return nil, err
+		}
+		versions = []*Manifest{adopted}
+		current = adopted
+	}
+
+	entry := &TrashEntry{
+		ID:        xid.New().String(),
+		Name:      name,
+		DeletedAt: time.Now().UTC(),
+		Versions:  versions,
+	}
+	if current != nil {
+		entry.CurrentVersion = current.version()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	// Once the entry is written the file can be restored, everything after is cleanup
+	if _, err := trash.fh.minioClient.PutObject(ctx, bytes.NewReader(data), int64(len(data)), getTrashName(entry.ID), "application/json"); err != nil {
+		return nil, err
+	}
+
+	toRemove := []string{getManifestName(name)}
+	for _, version := range versions {
+		toRemove = append(toRemove, getVersionName(name, version.version()))
+	}
+	toRemove = append(toRemove, legacyObjects...)
+	for _, object := range toRemove {
+		if err := trash.fh.minioClient.RemoveObject(ctx, object); err != nil {
+			slog.Warn("Failed to remove object of deleted file", "object", object, "file", name, "error", err)
+		}
+	}
+	slog.Info("Moved file to trash", "file", name, "versions", len(versions), "trashId", entry.ID)
+	return entry, nil
+}
+
+var errTrashConflict = errors.New("a file with the same name exists")
+
+// Restores every version of the deleted file. Fails if a file with the same name was uploaded since
+func (trash *Trash) Restore(ctx context.Context, trashId string) (*TrashEntry, error) {
+	trash.mu.Lock()
+	defer trash.mu.Unlock()
+
+	entry, err := trash.fh.readTrashEntry(ctx, trashId)
+	if err != nil {
+		return nil, err
+	}
+	defer trash.fh.lockName(entry.Name)()
+	if _, err := trash.fh.readManifest(ctx, entry.Name); err == nil {
+		return nil, errTrashConflict
+	} else if !client.IsNotFound(err) {
+		return nil, err
+	}
+
+	var current *Manifest
+	for _, version := range entry.Versions {
+		if err := trash.fh.putManifest(ctx, version, getVersionName(entry.Name, version.version()), version.Lock); err != nil {
+			return nil, err
+		}
+		if version.version() == entry.CurrentVersion {
+			current = version
+		}
+	}
+	if current != nil {
+		if err := trash.fh.putManifest(ctx, current, getManifestName(entry.Name), nil); err != nil {
+			return nil, err
+		}
+	}
+	if err := trash.fh.minioClient.RemoveObject(ctx, getTrashName(trashId)); err != nil {
+		slog.Warn("Failed to remove trash entry of restored file", "trashId", trashId, "file", entry.Name, "error", err)
+	}
+	return entry, nil
+}
+
+// Removes data of every entry that is past the retention period.
+// Objects still referenced by a live file are kept
+func (trash *Trash) Purge(ctx context.Context) error {
+	trash.mu.Lock()
+	defer trash.mu.Unlock()
+
+	entries, err := trash.fh.readTrash(ctx)
+	if err != nil {
+		return err
+	}
+	manifests, err := trash.fh.readAllManifests(ctx)
+	if err != nil {
+		return err
+	}
+	live := make(map[string]bool)
+	for _, manifest := range manifests {
+		for _, object := range manifest.Objects {
+			live[object.Name] = true
+		}
+	}
+
+	for _, entry := range entries {
+		if time.Since(entry.DeletedAt) < trash.configuration.Retention {
+			continue
+		}
+		failed := false
+		for _, version := range entry.Versions {
+			for _, object := range version.Objects {
+				if live[object.Name] {
+					continue
+				}
+				if err := trash.fh.minioClient.RemoveObject(ctx, object.Name); err != nil {
+					slog.Warn("Failed to purge object", "object", object.Name, "file", entry.Name, "error", err)
+					failed = true
+				}
+			}
+		}
+		// Keep the entry so the next purge retries the failed objects
+		if failed {
+			continue
+		}
+		if err := trash.fh.minioClient.RemoveObject(ctx, getTrashName(entry.ID)); err != nil {
+			slog.Warn("Failed to remove trash entry", "trashId", entry.ID, "error", err)
+			continue
+		}
+		slog.Info("Purged deleted file", "file", entry.Name, "deletedAt", entry.DeletedAt)
+	}
+	return nil
+}
+
+// Moves file/`name` to the trash
+func (trash *Trash) DeleteFileHandler(c *gin.Context) {
+	name := c.Param("name")
+
+	entry, err := trash.Delete(c.Request.Context(), name)
+	var lockedErr *LockedError
+	if errors.As(err, &lockedErr) {
+		c.JSON(http.StatusLocked, gin.H{
+			"message": fmt.Sprintf("Cannot delete %s, %s", name, lockedErr.Error()),
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Could not delete file",
+		})
+		return
+	}
+	if entry == nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Could not find file",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"trashId": entry.ID,
+		"purgeAt": entry.DeletedAt.Add(trash.configuration.Retention),
+	})
+}
+
+// Lists deleted files that can still be restored
+func (trash *Trash) ListTrashHandler(c *gin.Context) {
+	entries, err := trash.fh.readTrash(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Could not list trash",
+		})
+		return
+	}
+
+	result := make([]gin.H, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, gin.H{
+			"id":        entry.ID,
+			"name":      entry.Name,
+			"deletedAt": entry.DeletedAt,
+			"purgeAt":   entry.DeletedAt.Add(trash.configuration.Retention),
+			"versions":  len(entry.Versions),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"files": result,
+	})
+}
+
+// Restores the deleted file on trash/`id`/restore
+func (trash *Trash) RestoreTrashHandler(c *gin.Context) {
+	trashId := c.Param("id")
+
+	entry, err := trash.Restore(c.Request.Context(), trashId)
+	if err != nil {
+		if errors.Is(err, errTrashConflict) {
+			c.JSON(http.StatusConflict, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		if client.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Could not find deleted file",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Could not restore file",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"name":   entry.Name,
+	})
+}
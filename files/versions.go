@@ -0,0 +1,109 @@
+package files
+
+import (
+	"net/http"
+	"time"
+
+	"taurus-minio/client"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/xid"
+)
+
+// Version as returned by the version list
+type VersionInfo struct {
+	VersionID    string    `json:"versionId"`
+	UploadID     string    `json:"uploadId"`
+	RestoredFrom string    `json:"restoredFrom,omitempty"`
+	Chunked      bool      `json:"chunked"`
+	Chunks       int       `json:"chunks"`
+	StoredSize   int64     `json:"storedSize"`
+	CreatedAt    time.Time `json:"createdAt"`
+	Current      bool      `json:"current"`
+}
+
+// Lists all versions of the file on file/`name`/versions, newest first
+func (fh *FileHandler) ListVersionsHandler(c *gin.Context) {
+	name := c.Param("name")
+	ctx := c.Request.Context()
+
+	versions, err := fh.listVersions(ctx, name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Could not list file versions",
+		})
+		return
+	}
+	if len(versions) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"message": "Could not find file versions",
+		})
+		return
+	}
+
+	current, err := fh.readManifest(ctx, name)
+	if err != nil && !client.IsNotFound(err) {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Could not read file manifest",
+		})
+		return
+	}
+
+	result := make([]VersionInfo, 0, len(versions))
+	for _, version := range versions {
+		result = append(result, VersionInfo{
+			VersionID:    version.version(),
+			UploadID:     version.UploadID,
+			RestoredFrom: version.RestoredFrom,
+			Chunked:      version.Chunked,
+			Chunks:       len(version.Objects),
+			StoredSize:   version.size(),
+			CreatedAt:    version.CreatedAt,
+			Current:      current != nil && current.version() == version.version(),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"name":     name,
+		"versions": result,
+	})
+}
+
+// Restores file/`name` to the version on versions/`version`/restore.
+// The restored content becomes a new current version sharing the stored objects with the old one,
+// so the history in between is kept
+func (fh *FileHandler) RestoreVersionHandler(c *gin.Context) {
+	name := c.Param("name")
+	versionId := c.Param("version")
+	ctx := c.Request.Context()
+
+	version, err := fh.readVersion(ctx, name, versionId)
+	if err != nil {
+		if client.IsNotFound(err) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"message": "Could not find file version",
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Could not read file version",
+		})
+		return
+	}
+
+	restored := *version
+	restored.VersionID = xid.New().String()
+	restored.RestoredFrom = version.version()
+	restored.CreatedAt = time.Now().UTC()
+	if err := fh.commitManifest(ctx, &restored); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Error committing restored version",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":       "success",
+		"versionId":    restored.VersionID,
+		"restoredFrom": restored.RestoredFrom,
+	})
+}
@@ -0,0 +1,152 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/xid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Header carrying the request id. Taken from the request if present, always set on the response
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+const REDACTED = "[REDACTED]"
+
+// Attributes whose key contains any of these are never written to the log
+var secretKeys = []string{"secret", "password", "accesskey", "encryptionkey", "token", "authorization", "credential"}
+
+// Level of the default logger, can be changed at runtime
+var level = new(slog.LevelVar)
+
+type requestIdKey struct{}
+
+type clientKey struct{}
+
+// Makes JSON logger with the given level the default for slog.
+// Output of the standard log package is redirected to it as well
+func Init(levelName string) error {
+	if err := SetLevel(levelName); err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(NewHandler(os.Stdout)))
+	return nil
+}
+
+// JSON handler that redacts secrets and uses the shared level
+func NewHandler(w io.Writer) slog.Handler {
+	return slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	})
+}
+
+// Changes level of the default logger. Takes debug, info, warn or error
+func SetLevel(levelName string) error {
+	if levelName == "" {
+		levelName = "info"
+	}
+	var parsed slog.Level
+	if err := parsed.UnmarshalText([]byte(levelName)); err != nil {
+		return fmt.Errorf("invalid log level %q, use debug, info, warn or error", levelName)
+	}
+	level.Set(parsed)
+	return nil
+}
+
+func redact(groups []string, attr slog.Attr) slog.Attr {
+	key := strings.ToLower(attr.Key)
+	for _, secret := range secretKeys {
+		if strings.Contains(key, secret) {
+			return slog.String(attr.Key, REDACTED)
+		}
+	}
+	return attr
+}
+
+// Logs the error with the request and trace ids of the context and exits, replaces log.Fatal
+func Fatal(ctx context.Context, msg string, args ...any) {
+	FromContext(ctx).Error(msg, args...)
+	os.Exit(1)
+}
+
+// Returns context carrying the request id
+func WithRequestID(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, requestId)
+}
+
+// Request id stored in the context, empty if there is none
+func RequestID(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIdKey{}).(string)
+	return requestId
+}
+
+// Returns context carrying the name of the authenticated client
+func WithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// Logger with the request and trace ids and the authenticated client of the context attached
+func FromContext(ctx context.Context) *slog.Logger {
+	logger := slog.Default()
+	if requestId := RequestID(ctx); requestId != "" {
+		logger = logger.With("requestId", requestId)
+	}
+	if client, _ := ctx.Value(clientKey{}).(string); client != "" {
+		logger = logger.With("clientIdentity", client)
+	}
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		logger = logger.With("traceId", spanContext.TraceID().String())
+	}
+	return logger
+}
+
+// Routes of the health probes, logged at debug level unless they fail
+var probeRoutes = map[string]bool{"/healthz": true, "/readyz": true}
+
+// Routes whose path holds a secret, e.g. the token of share links. Only the route is logged for them
+var secretRoutes = map[string]bool{"/shared/:token": true}
+
+// Path of the request as it may be logged or traced
+func RequestPath(c *gin.Context) string {
+	if secretRoutes[c.FullPath()] {
+		return c.FullPath()
+	}
+	return c.Request.URL.Path
+}
+
+// Gin middleware assigning a request id to every request and writing the access log
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		requestId := c.GetHeader(REQUEST_ID_HEADER)
+		if requestId == "" || len(requestId) > 128 {
+			requestId = xid.New().String()
+		}
+		c.Header(REQUEST_ID_HEADER, requestId)
+		c.Request = c.Request.WithContext(WithRequestID(c.Request.Context(), requestId))
+
+		c.Next()
+
+		// Successful health probes run every few seconds and would drown the other requests
+		level := slog.LevelInfo
+		if probeRoutes[c.FullPath()] && c.Writer.Status() < 400 {
+			level = slog.LevelDebug
+		}
+		FromContext(c.Request.Context()).Log(c.Request.Context(), level, "request",
+			"method", c.Request.Method,
+			"path", RequestPath(c),
+			"route", c.FullPath(),
+			"status", c.Writer.Status(),
+			"bytes", c.Writer.Size(),
+			"duration", time.Since(start),
+			"client", c.ClientIP(),
+		)
+	}
+}
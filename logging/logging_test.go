@@ -0,0 +1,115 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func captureDefault(t *testing.T) *bytes.Buffer {
+	var buffer bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(NewHandler(&buffer)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buffer
+}
+
+func TestRedact(t *testing.T) {
+	buffer := captureDefault(t)
+	slog.Info("configuration", "secretAccessKey", "s3cr3t", "EncryptionKey", "abcd", "endpoint", "localhost:9000")
+
+	output := buffer.String()
+	if strings.Contains(output, "s3cr3t") || strings.Contains(output, "abcd") {
+		t.Fatalf("secret written to log: %s", output)
+	}
+	if !strings.Contains(output, "localhost:9000") {
+		t.Fatalf("endpoint missing in log: %s", output)
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	buffer := captureDefault(t)
+	defer SetLevel("info")
+
+	if err := SetLevel("warn"); err != nil {
+		t.Fatal(err)
+	}
+	slog.Info("hidden")
+	if buffer.Len() != 0 {
+		t.Fatalf("info logged on warn level: %s", buffer.String())
+	}
+	if err := SetLevel("verbose"); err == nil {
+		t.Fatal("expected error for unknown level")
+	}
+}
+
+func TestMiddlewareRequestID(t *testing.T) {
+	buffer := captureDefault(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware())
+	var seen string
+	router.GET("/ping", func(c *gin.Context) {
+		seen = RequestID(c.Request.Context())
+		FromContext(c.Request.Context()).Info("handled")
+	})
+
+	// Generated when the client sends none
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/ping", nil))
+	generated := recorder.Header().Get(REQUEST_ID_HEADER)
+	if generated == "" || generated != seen {
+		t.Fatalf("response id %q, handler id %q", generated, seen)
+	}
+
+	// Taken over from the client
+	buffer.Reset()
+	request := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	request.Header.Set(REQUEST_ID_HEADER, "abc-123")
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	if got := recorder.Header().Get(REQUEST_ID_HEADER); got != "abc-123" {
+		t.Fatalf("expected client request id, got %q", got)
+	}
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected handler and access log line, got %d", len(lines))
+	}
+	for _, line := range lines {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry["requestId"] != "abc-123" {
+			t.Fatalf("request id missing in %s", line)
+		}
+	}
+}
+
+func TestMiddlewareSecretPath(t *testing.T) {
+	buffer := captureDefault(t)
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware())
+	router.GET("/shared/:token", func(c *gin.Context) {})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/shared/s3cr3t-token", nil))
+	if output := buffer.String(); strings.Contains(output, "s3cr3t-token") || !strings.Contains(output, "/shared/:token") {
+		t.Errorf("access log of a share link: %s", output)
+	}
+}
+
+func TestFromContextWithoutRequest(t *testing.T) {
+	buffer := captureDefault(t)
+	FromContext(context.Background()).Info("background")
+	if strings.Contains(buffer.String(), "requestId") {
+		t.Fatalf("unexpected request id: %s", buffer.String())
+	}
+}
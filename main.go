@@ -1,29 +1,195 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"taurus-minio/api"
 	"taurus-minio/client"
 	"taurus-minio/encryption"
 	"taurus-minio/files"
+	"taurus-minio/logging"
+	"taurus-minio/metrics"
+	"taurus-minio/server"
+	"taurus-minio/tracing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// Time cancelled uploads get to clean up on shutdown
+const SHUTDOWN_CLEANUP_TIMEOUT = 10 * time.Second
+
 func main() {
-	// Create minio client and read configuration files
-	minioClient := client.CreateMinioClient()
-	log.Printf("Is Online: %t\n", minioClient.IsOnline())
+	// Log as JSON from the start, the configured level is applied once the configuration is read
+	if err := logging.Init("info"); err != nil {
+		logging.Fatal(context.Background(), "Failed to set up logging", "error", err)
+	}
+	configPath := flag.String("config", os.Getenv("TAURUS_CONFIG"), "configuration file (.toml, .json or .yaml), defaults to "+client.DEFAULT_CONFIG_FILE)
+	flag.Parse()
+	// Read configuration file and environment
+	config, err := client.LoadConfiguration(*configPath)
+	if err != nil {
+		logging.Fatal(context.Background(), "Failed to load configuration", "error", err)
+	}
+	if err := logging.SetLevel(config.Logging.Level); err != nil {
+		logging.Fatal(context.Background(), "Invalid logging configuration", "error", err)
+	}
+	// Create minio client
+	minioClient := client.CreateMinioClient(config)
+	// Propagate trace context and export spans if enabled
+	shutdownTracing, err := tracing.Init(minioClient.GetTracingConfiguration())
+	if err != nil {
+		logging.Fatal(context.Background(), "Failed to set up tracing", "error", err)
+	}
+	defer shutdownTracing(context.Background())
+	slog.Info("Checked minio", "online", minioClient.IsOnline())
 	// Typically for first start, if no bucket is present.
-	minioClient.CreateBucket()
-	// Create cryptographer for encrypting/decrypting
-	cryptographer := encryption.InitEncrypter(minioClient.GetEncryptionKey())
+	minioClient.CreateBucket(context.Background())
+	// Create keys for encrypting/decrypting
+	keyring := encryption.InitKeyring(minioClient.GetEncryptionKey(), minioClient.GetPreviousEncryptionKeys()...)
 
 	// Create file handler, responsible for receiving/sending/chunking/encrypting files
-	fh := files.InitFileHandler(minioClient, cryptographer)
+	fh := files.InitFileHandler(minioClient, keyring)
+
+	// Create integrity scrubber, runs in the background if enabled
+	scrubber := files.InitScrubber(fh, minioClient.GetScrubberConfiguration())
+	scrubber.Start()
+
+	// Create garbage collector for data of failed or replaced uploads
+	gc := files.InitGarbageCollector(fh, minioClient.GetGCConfiguration())
+	gc.Start()
+
+	// Create trash for deleted files, expired files are purged in the background
+	trash := files.InitTrash(fh, minioClient.GetTrashConfiguration())
+	trash.Start()
+
+	// Apply configuration changes on SIGHUP or when the file changes
+	reloader := client.InitReloader(minioClient, *configPath)
+	reloader.OnReload(func(config *client.Config) {
+		if err := logging.SetLevel(config.Logging.Level); err != nil {
+			// The previous level stays in effect
+			slog.Error("Invalid logging configuration, keeping the current level", "error", err)
+		}
+		fh.SetKeyring(encryption.InitKeyring(minioClient.GetEncryptionKey(), minioClient.GetPreviousEncryptionKeys()...))
+		scrubber.SetBytesPerSecond(config.Scrubber.BytesPerSecond)
+	})
+	reloader.Start()
+
+	// OpenAPI document of the routes, requests are validated against it
+	spec, err := api.Load()
+	if err != nil {
+		logging.Fatal(context.Background(), "Failed to load the API document", "error", err)
+	}
+
+	router := newRouter(spec, minioClient.GetTLSConfiguration(), fh, scrubber, gc, trash)
+	for _, route := range router.Routes() {
+		if !spec.Documents(route.Method, route.Path) {
+			slog.Warn("Route is missing from the API document", "method", route.Method, "path", route.Path)
+		}
+	}
+	serve(router, minioClient, fh)
+}
+
+// Creates the gin router serving the API
+func newRouter(spec *api.Spec, tlsConfiguration client.TLSConfiguration, fh *files.FileHandler, scrubber *files.Scrubber,
+	gc *files.GarbageCollector, trash *files.Trash) *gin.Engine {
+	router := gin.New()
+	// Names with slashes, e.g. files of archives, are sent with the slashes escaped as %2F.
+	// Routing on the escaped path keeps them in the :name parameter, which holds the unescaped name
+	router.UseRawPath = true
+	router.Use(gin.Recovery())
+	router.Use(tracing.Middleware())
+	router.Use(logging.Middleware())
+	router.Use(metrics.Middleware())
+	if tlsConfiguration.Enabled {
+		router.Use(server.IdentityMiddleware(tlsConfiguration.AllowedClients))
+	}
+	router.Use(spec.Middleware())
+	router.POST("/upload/file", fh.UploadFilesHandler)                             // upload a file
+	router.GET("/file/:name", fh.GetFileFromIDHandler)                             // get file by id
+	router.PUT("/file/:name", fh.PutFileHandler)                                   // upload a file as request body
+	router.GET("/file/:name/versions", fh.ListVersionsHandler)                     // list versions of a file
+	router.GET("/files", fh.ListFilesHandler)                                      // list files by prefix
+	router.POST("/file/:name/share", fh.ShareFileHandler)                          // create a share link
+	router.GET("/shared/:token", fh.GetSharedFileHandler)                          // download a shared file
+	router.GET("/archive", fh.GetArchiveHandler)                                   // download files by prefix as archive
+	router.POST("/archive", fh.PostArchiveHandler)                                 // download listed files as archive
+	router.POST("/file/:name/versions/:version/restore", fh.RestoreVersionHandler) // restore a version
+	router.DELETE("/file/:name", trash.DeleteFileHandler)                          // move file to trash
+	router.GET("/trash", trash.ListTrashHandler)                                   // list deleted files
+	router.POST("/trash/:id/restore", trash.RestoreTrashHandler)                   // restore deleted file
+	router.POST("/admin/fsck", scrubber.StartScrubHandler)                         // start integrity check
+	router.GET("/admin/fsck", scrubber.GetScrubReportHandler)                      // last integrity report
+	router.POST("/admin/gc", gc.GCHandler)                                         // remove unreferenced data
+	router.GET("/metrics", metrics.Handler())                                      // prometheus metrics
+	router.GET("/healthz", fh.HealthHandler)                                       // process is alive
+	router.GET("/readyz", fh.ReadyHandler)                                         // minio, bucket and key are usable
+	router.GET("/openapi.json", api.Handler)                                       // API document
+	return router
+}
+
+// Runs the HTTP server until SIGINT or SIGTERM.
+// On shutdown new requests are refused and running ones get the shutdown timeout to finish.
+// Requests still running afterwards are cancelled, which makes uploads remove their partial data
+func serve(handler http.Handler, minioClient *client.MinioClient, fh *files.FileHandler) {
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	httpServer := &http.Server{
+		Addr:        minioClient.GetServerConfiguration().ListenAddress,
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return requestCtx },
+	}
+	tlsConfiguration := minioClient.GetTLSConfiguration()
+	if tlsConfiguration.Enabled {
+		certificates, err := server.InitCertificateReloader(tlsConfiguration)
+		if err != nil {
+			logging.Fatal(context.Background(), "Failed to load TLS certificates", "error", err)
+		}
+		certificates.Start()
+		httpServer.TLSConfig = certificates.Config()
+	}
+
+	stopCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		slog.Info("Listening", "address", httpServer.Addr, "tls", tlsConfiguration.Enabled)
+		var err error
+		if tlsConfiguration.Enabled {
+			// Certificates come from the TLS configuration
+			err = httpServer.ListenAndServeTLS("", "")
+		} else {
+			err = httpServer.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Fatal(context.Background(), "HTTP server failed", "error", err)
+		}
+	}()
+	<-stopCtx.Done()
+	stop()
+
+	timeout := minioClient.GetServerConfiguration().ShutdownTimeout
+	slog.Info("Shutting down, waiting for running requests", "timeout", timeout)
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), timeout)
+	defer cancelShutdown()
+	if err := httpServer.Shutdown(shutdownCtx); err == nil {
+		slog.Info("All requests finished")
+		return
+	}
 
-	// start gin
-	router := gin.Default()
-	router.POST("/upload/file", fh.UploadFilesHandler) // upload a file
-	router.GET("/file/:name", fh.GetFileFromIDHandler) // get file by id
-	router.Run(":8080")
+	slog.Warn("Requests did not finish in time, cancelling them")
+	cancelRequests()
+	// Give cancelled uploads a moment to remove their data, the garbage collection takes care of the rest
+	cleanupCtx, cancelCleanup := context.WithTimeout(context.Background(), SHUTDOWN_CLEANUP_TIMEOUT)
+	defer cancelCleanup()
+	if running := fh.WaitForUploads(cleanupCtx); running > 0 {
+		slog.Warn("Uploads still running on exit", "uploads", running)
+	}
+	httpServer.Close()
 }
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"taurus-minio/api"
+	"taurus-minio/client"
+	"taurus-minio/encryption"
+	"taurus-minio/files"
+	"taurus-minio/sdk"
+
+	"github.com/gin-gonic/gin"
+)
+
+const TEST_BUCKET = "taurus"
+
+type fakeObject struct {
+	data    []byte
+	etag    string
+	modTime time.Time
+}
+
+// In-memory bucket answering the S3 requests the file handler sends with chunking enabled:
+// single part uploads, downloads, stats, recursive listings and removals
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+type listResult struct {
+	XMLName     xml.Name `xml:"ListBucketResult"`
+	Name        string
+	Prefix      string
+	KeyCount    int
+	MaxKeys     int
+	IsTruncated bool
+	Contents    []listEntry
+}
+
+type listEntry struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int
+	StorageClass string
+}
+
+func (s3 *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s3.mu.Lock()
+	defer s3.mu.Unlock()
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"+TEST_BUCKET), "/")
+	if key == "" {
+		if r.URL.Query().Get("list-type") == "2" {
+			s3.list(w, r.URL.Query().Get("prefix"))
+			return
+		}
+		// Bucket exists
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		sum := md5.Sum(data)
+		object := fakeObject{data: data, etag: `"` + hex.EncodeToString(sum[:]) + `"`, modTime: time.Now().UTC().Truncate(time.Second)}
+		s3.objects[key] = object
+		w.Header().Set("ETag", object.etag)
+	case http.MethodGet, http.MethodHead:
+		object, ok := s3.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message><Key>%s</Key></Error>", key)
+			return
+		}
+		w.Header().Set("ETag", object.etag)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, "", object.modTime, bytes.NewReader(object.data))
+	case http.MethodDelete:
+		delete(s3.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (s3 *fakeS3) list(w http.ResponseWriter, prefix string) {
+	result := listResult{Name: TEST_BUCKET, Prefix: prefix, MaxKeys: 1000}
+	for key, object := range s3.objects {
+		if strings.HasPrefix(key, prefix) {
+			result.Contents = append(result.Contents, listEntry{
+				Key:          key,
+				LastModified: object.modTime.Format(time.RFC3339),
+				ETag:         object.etag,
+				Size:         len(object.data),
+				StorageClass: "STANDARD",
+			})
+		}
+	}
+	sort.Slice(result.Contents, func(i, j int) bool {
+		return result.Contents[i].Key < result.Contents[j].Key
+	})
+	result.KeyCount = len(result.Contents)
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// Serves the router of the server, storing the files in a fake bucket
+func testServer(t *testing.T) *httptest.Server {
+	gin.SetMode(gin.TestMode)
+	s3 := httptest.NewServer(&fakeS3{objects: make(map[string]fakeObject)})
+	t.Cleanup(s3.Close)
+	endpoint, _ := url.Parse(s3.URL)
+	config := &client.Config{
+		Minio: client.MinioConfiguration{
+			Endpoint:      endpoint.Host,
+			BucketName:    TEST_BUCKET,
+			Region:        "us-east-1",
+			EncryptionKey: "6368616e676520746869732070617373776f726420746f206120736563726574",
+			Chunking:      true,
+		},
+		Server: client.ServerConfiguration{
+			BlockSize:        client.DEFAULT_BLOCK_SIZE,
+			DownloadRoutines: 2,
+			DefaultChunkSize: "1MB",
+			ShutdownTimeout:  time.Second,
+		},
+		Retry: client.RetryConfiguration{MaxAttempts: 1},
+	}
+	minioClient := client.CreateMinioClient(config)
+	fh := files.InitFileHandler(minioClient, encryption.InitKeyring(minioClient.GetEncryptionKey()))
+	spec, err := api.Load()
+	if err != nil {
+		t.Fatalf("api.Load() error = %v", err)
+	}
+	router := newRouter(spec, config.TLS, fh, files.InitScrubber(fh, config.Scrubber),
+		files.InitGarbageCollector(fh, config.GC), files.InitTrash(fh, config.Trash))
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Files of an archive keep their directories in the name and are served like any other file
+func TestNestedArchiveEntries(t *testing.T) {
+	server := testServer(t)
+	ctx := context.Background()
+
+	var archive bytes.Buffer
+	w := tar.NewWriter(&archive)
+	content := "notes in a directory"
+	w.WriteHeader(&tar.Header{Name: "docs/notes.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))})
+	w.Write([]byte(content))
+	w.Close()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("archive", "docs.tar")
+	part.Write(archive.Bytes())
+	form.Close()
+	response, err := http.Post(server.URL+"/upload/file", form.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatalf("uploading the archive error = %v", err)
+	}
+	answer, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK || !strings.Contains(string(answer), `"docs/notes.txt"`) {
+		t.Fatalf("uploading the archive = %d %s", response.StatusCode, answer)
+	}
+
+	taurus := sdk.InitClient(server.URL, sdk.Options{MaxAttempts: 1})
+	reader, err := taurus.Download(ctx, "docs/notes.txt", "")
+	if err != nil {
+		t.Fatalf("Download() error = %v", err)
+	}
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil || string(data) != content {
+		t.Errorf("Download() = %q, %v, want %q", data, err, content)
+	}
+
+	listed, err := taurus.List(ctx, "docs/")
+	if err != nil || len(listed) != 1 || listed[0].Name != "docs/notes.txt" {
+		t.Errorf("List() = %+v, %v", listed, err)
+	}
+	version, err := taurus.Stat(ctx, "docs/notes.txt")
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	share, err := taurus.Share(ctx, "docs/notes.txt", "", time.Hour)
+	if err != nil || share.VersionID != version.VersionID {
+		t.Fatalf("Share() = %+v, %v", share, err)
+	}
+	response, err = http.Get(share.URL)
+	if err != nil {
+		t.Fatalf("downloading the share link error = %v", err)
+	}
+	data, _ = io.ReadAll(response.Body)
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK || string(data) != content {
+		t.Errorf("share link = %d %q, want %q", response.StatusCode, data, content)
+	}
+
+	// Without escaping, the slash separates path segments and matches no route
+	response, err = http.Get(server.URL + "/file/docs/notes.txt")
+	if err != nil {
+		t.Fatalf("GET with an unescaped name error = %v", err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNotFound {
+		t.Errorf("GET with an unescaped name = %d, want 404", response.StatusCode)
+	}
+}
@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus metrics of the application.
+// All metrics are registered in the default registry, which also exposes Go runtime metrics
+var (
+	uploads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "taurus_uploads_total",
+		Help: "Number of file uploads by result.",
+	}, []string{"status"})
+
+	downloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "taurus_downloads_total",
+		Help: "Number of file downloads by result.",
+	}, []string{"status"})
+
+	plaintextBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "taurus_plaintext_bytes_total",
+		Help: "Plaintext bytes received from clients (upload) and sent to clients (download).",
+	}, []string{"direction"})
+
+	ciphertextBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "taurus_ciphertext_bytes_total",
+		Help: "Encrypted bytes sent to minio (upload) and received from minio (download).",
+	}, []string{"direction"})
+
+	chunks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "taurus_chunks_total",
+		Help: "Number of chunks uploaded and downloaded.",
+	}, []string{"direction"})
+
+	cryptoBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "taurus_crypto_bytes_total",
+		Help: "Plaintext bytes processed by encryption and decryption.",
+	}, []string{"operation"})
+
+	cryptoSeconds = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "taurus_crypto_seconds_total",
+		Help: "Time spent in encryption and decryption. Throughput is taurus_crypto_bytes_total / taurus_crypto_seconds_total.",
+	}, []string{"operation"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "taurus_http_request_duration_seconds",
+		Help:    "Latency of HTTP requests by handler.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"handler", "method", "code"})
+
+	minioDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "taurus_minio_request_duration_seconds",
+		Help:    "Latency of minio calls by operation.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"operation"})
+
+	minioErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "taurus_minio_errors_total",
+		Help: "Failed minio calls by operation.",
+	}, []string{"operation"})
+
+	minioRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "taurus_minio_retries_total",
+		Help: "Retried minio operations by operation.",
+	}, []string{"operation"})
+
+	circuitOpen = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "taurus_minio_circuit_open",
+		Help: "1 while the circuit breaker fails minio operations fast, 0 otherwise.",
+	})
+
+	chunkWorkers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "taurus_chunk_workers",
+		Help: "Number of running chunk retrieval goroutines.",
+	})
+)
+
+// Directions used for byte and chunk counters
+const (
+	UPLOAD   = "upload"
+	DOWNLOAD = "download"
+)
+
+// Operations used for crypto counters
+const (
+	ENCRYPT = "encrypt"
+	DECRYPT = "decrypt"
+)
+
+func result(code int) string {
+	if code >= 400 {
+		return "error"
+	}
+	return "success"
+}
+
+// Counts finished upload by its HTTP status code
+func CountUpload(code int) {
+	uploads.WithLabelValues(result(code)).Inc()
+}
+
+// Counts finished download by its HTTP status code
+func CountDownload(code int) {
+	downloads.WithLabelValues(result(code)).Inc()
+}
+
+func AddPlaintextBytes(direction string, n int) {
+	plaintextBytes.WithLabelValues(direction).Add(float64(n))
+}
+
+func AddCiphertextBytes(direction string, n int) {
+	ciphertextBytes.WithLabelValues(direction).Add(float64(n))
+}
+
+func CountChunk(direction string) {
+	chunks.WithLabelValues(direction).Inc()
+}
+
+// Records n plaintext bytes encrypted or decrypted in the given time
+func ObserveCrypto(operation string, n int, duration time.Duration) {
+	cryptoBytes.WithLabelValues(operation).Add(float64(n))
+	cryptoSeconds.WithLabelValues(operation).Add(duration.Seconds())
+}
+
+// Records latency of a minio call and counts it as failed if err is set
+func ObserveMinio(operation string, start time.Time, err error) {
+	minioDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		minioErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+func CountMinioRetry(operation string) {
+	minioRetries.WithLabelValues(operation).Inc()
+}
+
+func SetCircuitOpen(open bool) {
+	if open {
+		circuitOpen.Set(1)
+	} else {
+		circuitOpen.Set(0)
+	}
+}
+
+// Tracks a running chunk retrieval goroutine. Call the returned function when it exits
+func StartChunkWorker() func() {
+	chunkWorkers.Inc()
+	return chunkWorkers.Dec
+}
+
+// Gin middleware recording latency of every request by its route
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		handler := c.FullPath()
+		if handler == "" {
+			handler = "unmatched"
+		}
+		httpDuration.WithLabelValues(handler, c.Request.Method, strconv.Itoa(c.Writer.Status())).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler serving the metrics in Prometheus text format
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
@@ -0,0 +1,80 @@
+package sdk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Kinds of failures, match them with errors.Is
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrLocked       = errors.New("locked by retention or legal hold")
+	ErrUnavailable  = errors.New("server unavailable")
+	ErrServerFailed = errors.New("server error")
+	// Range starting after the end of the file
+	ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+)
+
+// Error answered by the server, its body is {"message": "...", "errors": [...]}
+type Error struct {
+	StatusCode int
+	Message    string
+	// Invalid fields of a rejected request
+	Fields []FieldError
+}
+
+// Field of a request that does not match the API document
+type FieldError struct {
+	Field   string `json:"field"`
+	In      string `json:"in"`
+	Message string `json:"message"`
+}
+
+func (err *Error) Error() string {
+	if err.Message == "" {
+		return fmt.Sprintf("taurus: %d %s", err.StatusCode, http.StatusText(err.StatusCode))
+	}
+	return fmt.Sprintf("taurus: %d %s", err.StatusCode, err.Message)
+}
+
+// Kind of the error by status code, nil if there is none
+func (err *Error) Unwrap() error {
+	switch err.StatusCode {
+	case http.StatusBadRequest:
+		return ErrBadRequest
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrForbidden
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusConflict:
+		return ErrConflict
+	case http.StatusLocked:
+		return ErrLocked
+	case http.StatusRequestedRangeNotSatisfiable:
+		return ErrRangeNotSatisfiable
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return ErrUnavailable
+	}
+	if err.StatusCode >= 500 {
+		return ErrServerFailed
+	}
+	return nil
+}
+
+// Reads the error of a response that did not succeed and closes its body
+func readError(response *http.Response) error {
+	defer response.Body.Close()
+	var body struct {
+		Message string       `json:"message"`
+		Errors  []FieldError `json:"errors"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+	json.Unmarshal(data, &body)
+	return &Error{StatusCode: response.StatusCode, Message: body.Message, Fields: body.Errors}
+}
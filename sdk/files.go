@@ -0,0 +1,328 @@
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Optional settings of an upload
+type UploadOptions struct {
+	// Size of the chunks, e.g. 64MB. Only used if the server stores files in chunks, defaults to its server.defaultChunkSize
+	ChunkSize string
+	// Called with the total bytes read from the file so far, starts over if the upload is retried
+	Progress func(sent int64)
+	// WORM protection, requires a bucket with object locking. Mode is GOVERNANCE or COMPLIANCE
+	RetentionMode string
+	RetainUntil   time.Time
+	LegalHold     bool
+	// Media type of the file, detected by the server if empty
+	ContentType string
+	// Custom metadata, sent as X-Meta-* headers and answered with every download
+	Metadata map[string]string
+}
+
+// Result of an upload
+type UploadResult struct {
+	VersionID string `json:"versionId"`
+	// ETag of the stored object, empty for chunked uploads
+	ETag string `json:"ETag"`
+	// ETags of the stored chunks, empty if the file was not chunked
+	Tags []string `json:"Tags"`
+}
+
+// Version of a file, as listed by Versions
+type Version struct {
+	VersionID    string    `json:"versionId"`
+	UploadID     string    `json:"uploadId"`
+	RestoredFrom string    `json:"restoredFrom,omitempty"`
+	Chunked      bool      `json:"chunked"`
+	Chunks       int       `json:"chunks"`
+	StoredSize   int64     `json:"storedSize"`
+	CreatedAt    time.Time `json:"createdAt"`
+	Current      bool      `json:"current"`
+}
+
+// Current version of a file, as listed by List
+type File struct {
+	Name      string `json:"name"`
+	VersionID string `json:"versionId"`
+	// Plaintext bytes
+	Size        int64     `json:"size"`
+	ContentType string    `json:"contentType"`
+	ModTime     time.Time `json:"modTime"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Chunked     bool      `json:"chunked"`
+}
+
+// Link to a version of a file, valid for anyone holding it until ExpiresAt
+type Share struct {
+	URL       string
+	VersionID string
+	ExpiresAt time.Time
+}
+
+// Deleted file that can be restored until PurgeAt
+type TrashEntry struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	DeletedAt time.Time `json:"deletedAt"`
+	PurgeAt   time.Time `json:"purgeAt"`
+	Versions  int       `json:"versions"`
+}
+
+// Uploads file as name, streaming it as multipart form.
+// Failed uploads are only retried if file is an io.Seeker, e.g. an *os.File, a stream can only be sent once
+func (client *Client) Upload(ctx context.Context, name string, file io.Reader, options UploadOptions) (*UploadResult, error) {
+	seeker, seekable := file.(io.Seeker)
+	offset := int64(0)
+	if seekable {
+		var err error
+		if offset, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+
+	response, err := client.do(ctx, seekable, func() (*http.Request, error) {
+		if seekable {
+			if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+				return nil, err
+			}
+		}
+		body, contentType := uploadForm(name, &progressReader{reader: file, progress: options.Progress}, options)
+		request, err := http.NewRequestWithContext(ctx, http.MethodPost, client.baseURL+"/upload/file", body)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Content-Type", contentType)
+		for key, value := range options.Metadata {
+			request.Header.Set("X-Meta-"+key, value)
+		}
+		return request, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	var result UploadResult
+	return &result, json.NewDecoder(response.Body).Decode(&result)
+}
+
+// Writes the multipart form of the upload while it is sent.
+// The fields come first, so the server stores the file with its settings right away
+func uploadForm(name string, file io.Reader, options UploadOptions) (io.Reader, string) {
+	r, w := io.Pipe()
+	form := multipart.NewWriter(w)
+	go func() {
+		fields := map[string]string{"chunk-size": options.ChunkSize, "retention-mode": options.RetentionMode}
+		if !options.RetainUntil.IsZero() {
+			fields["retain-until"] = options.RetainUntil.UTC().Format(time.RFC3339)
+		}
+		if options.LegalHold {
+			fields["legal-hold"] = strconv.FormatBool(true)
+		}
+		for field, value := range fields {
+			if value == "" {
+				continue
+			}
+			if err := form.WriteField(field, value); err != nil {
+				w.CloseWithError(err)
+				return
+			}
+		}
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": "upload", "filename": name}))
+		if options.ContentType != "" {
+			header.Set("Content-Type", options.ContentType)
+		}
+		part, err := form.CreatePart(header)
+		if err == nil {
+			_, err = io.Copy(part, file)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		w.CloseWithError(err)
+	}()
+	return r, form.FormDataContentType()
+}
+
+// Reports the bytes read so far
+type progressReader struct {
+	reader   io.Reader
+	progress func(sent int64)
+	sent     int64
+}
+
+func (reader *progressReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+	reader.sent += int64(n)
+	if n > 0 && reader.progress != nil {
+		reader.progress(reader.sent)
+	}
+	return n, err
+}
+
+// Downloads the current version of the file, or the given version if it is not empty.
+// The caller closes the returned reader. Reading fails if the file turns out to be corrupt while it is sent
+func (client *Client) Download(ctx context.Context, name string, version string) (io.ReadCloser, error) {
+	path := "/file/" + url.PathEscape(name)
+	if version != "" {
+		path += "?version=" + url.QueryEscape(version)
+	}
+	response, err := client.do(ctx, true, func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, client.baseURL+path, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response.Body, nil
+}
+
+// Downloads length bytes of the file starting at offset, a negative length reads to the end.
+// Fails with ErrRangeNotSatisfiable if offset is not before the end of the file
+func (client *Client) DownloadRange(ctx context.Context, name string, version string, offset int64, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	path := "/file/" + url.PathEscape(name)
+	if version != "" {
+		path += "?version=" + url.QueryEscape(version)
+	}
+	byteRange := "bytes=" + strconv.FormatInt(offset, 10) + "-"
+	if length > 0 {
+		byteRange += strconv.FormatInt(offset+length-1, 10)
+	}
+	response, err := client.do(ctx, true, func() (*http.Request, error) {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, client.baseURL+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		request.Header.Set("Range", byteRange)
+		return request, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusPartialContent {
+		return response.Body, nil
+	}
+	// A proxy in between dropped the range, the whole file is sent
+	if _, err := io.CopyN(io.Discard, response.Body, offset); err != nil {
+		response.Body.Close()
+		if err == io.EOF {
+			return nil, &Error{StatusCode: http.StatusRequestedRangeNotSatisfiable}
+		}
+		return nil, err
+	}
+	if length < 0 {
+		return response.Body, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(response.Body, length), response.Body}, nil
+}
+
+// Current version of every file whose name starts with prefix, sorted by name. An empty prefix lists every file
+func (client *Client) List(ctx context.Context, prefix string) ([]File, error) {
+	var result struct {
+		Files []File `json:"files"`
+	}
+	err := client.doJSON(ctx, http.MethodGet, "/files?prefix="+url.QueryEscape(prefix), true, &result)
+	return result.Files, err
+}
+
+// Creates a link to the current version of the file, or the given version if it is not empty.
+// The link is valid for expires, the server default of 24h if it is zero
+func (client *Client) Share(ctx context.Context, name string, version string, expires time.Duration) (*Share, error) {
+	query := url.Values{}
+	if version != "" {
+		query.Set("version", version)
+	}
+	if expires != 0 {
+		query.Set("expires", expires.String())
+	}
+	path := "/file/" + url.PathEscape(name) + "/share"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	var result struct {
+		Path      string    `json:"path"`
+		VersionID string    `json:"versionId"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+	// Creating a link changes nothing on the server, it can be sent again
+	if err := client.doJSON(ctx, http.MethodPost, path, true, &result); err != nil {
+		return nil, err
+	}
+	return &Share{URL: client.baseURL + result.Path, VersionID: result.VersionID, ExpiresAt: result.ExpiresAt}, nil
+}
+
+// Versions of the file, newest first
+func (client *Client) Versions(ctx context.Context, name string) ([]Version, error) {
+	var result struct {
+		Versions []Version `json:"versions"`
+	}
+	err := client.doJSON(ctx, http.MethodGet, "/file/"+url.PathEscape(name)+"/versions", true, &result)
+	return result.Versions, err
+}
+
+// Current version of the file. Fails with ErrNotFound if the file does not exist or is deleted
+func (client *Client) Stat(ctx context.Context, name string) (*Version, error) {
+	versions, err := client.Versions(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range versions {
+		if version.Current {
+			return &version, nil
+		}
+	}
+	return nil, &Error{StatusCode: http.StatusNotFound, Message: "File has no current version"}
+}
+
+// Makes an older version the current one again, returns the id of the new version
+func (client *Client) Restore(ctx context.Context, name string, version string) (string, error) {
+	var result struct {
+		VersionID string `json:"versionId"`
+	}
+	path := "/file/" + url.PathEscape(name) + "/versions/" + url.PathEscape(version) + "/restore"
+	err := client.doJSON(ctx, http.MethodPost, path, false, &result)
+	return result.VersionID, err
+}
+
+// Moves the file to the trash, returns the entry to restore it with
+func (client *Client) Delete(ctx context.Context, name string) (*TrashEntry, error) {
+	var result struct {
+		TrashID string    `json:"trashId"`
+		PurgeAt time.Time `json:"purgeAt"`
+	}
+	if err := client.doJSON(ctx, http.MethodDelete, "/file/"+url.PathEscape(name), false, &result); err != nil {
+		return nil, err
+	}
+	return &TrashEntry{ID: result.TrashID, Name: name, PurgeAt: result.PurgeAt}, nil
+}
+
+// Deleted files that can still be restored
+func (client *Client) Trash(ctx context.Context) ([]TrashEntry, error) {
+	var result struct {
+		Files []TrashEntry `json:"files"`
+	}
+	err := client.doJSON(ctx, http.MethodGet, "/trash", true, &result)
+	return result.Files, err
+}
+
+// Restores a deleted file by the id of its trash entry
+func (client *Client) RestoreTrash(ctx context.Context, id string) error {
+	var result struct{}
+	return client.doJSON(ctx, http.MethodPost, "/trash/"+url.PathEscape(id)+"/restore", false, &result)
+}
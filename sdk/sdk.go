@@ -0,0 +1,117 @@
+// Package sdk is the Go client of the taurus HTTP API
+package sdk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Settings of a Client, zero values use the defaults
+type Options struct {
+	// Client sending the requests, e.g. with TLS client certificates. Defaults to http.DefaultClient
+	HTTPClient *http.Client
+	// Attempts of a request including the first one, defaults to 3. 1 disables retries
+	MaxAttempts int
+	// Delay before the first retry, doubled for every further one up to MaxBackoff. Delays are jittered
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// Client of a taurus server, safe for concurrent use
+type Client struct {
+	baseURL string
+	options Options
+}
+
+// Creates client for the server at baseURL, e.g. http://localhost:8080
+func InitClient(baseURL string, options Options) *Client {
+	if options.HTTPClient == nil {
+		options.HTTPClient = http.DefaultClient
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 3
+	}
+	if options.InitialBackoff <= 0 {
+		options.InitialBackoff = 100 * time.Millisecond
+	}
+	if options.MaxBackoff < options.InitialBackoff {
+		options.MaxBackoff = 5 * time.Second
+	}
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), options: options}
+}
+
+// Builds a request, called again for every attempt so the body can be sent again
+type requestFunc func() (*http.Request, error)
+
+// Sends the request and returns the successful response, the caller closes its body.
+// Requests failing with a transient error are retried if retryable, other failures are returned as *Error
+func (client *Client) do(ctx context.Context, retryable bool, newRequest requestFunc) (*http.Response, error) {
+	attempts := client.options.MaxAttempts
+	if !retryable {
+		attempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		request, err := newRequest()
+		if err != nil {
+			return nil, err
+		}
+		response, err := client.options.HTTPClient.Do(request)
+		if err == nil && response.StatusCode < 300 {
+			return response, nil
+		}
+		if err == nil {
+			err = readError(response)
+		}
+		if attempt >= attempts || !isRetryable(err) {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(client.backoff(attempt)):
+		}
+	}
+}
+
+// Whether the request may succeed later, e.g. while the server restarts or minio is overloaded
+func isRetryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, ErrUnavailable) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Delay before the given retry (1 for the first one): exponential backoff with full jitter
+func (client *Client) backoff(retry int) time.Duration {
+	delay := client.options.InitialBackoff
+	for i := 1; i < retry && delay < client.options.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > client.options.MaxBackoff {
+		delay = client.options.MaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+// Sends a request without body and decodes the JSON answer into result
+func (client *Client) doJSON(ctx context.Context, method string, path string, retryable bool, result any) error {
+	response, err := client.do(ctx, retryable, func() (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, method, client.baseURL+path, nil)
+	})
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	return json.NewDecoder(response.Body).Decode(result)
+}
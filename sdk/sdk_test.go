@@ -0,0 +1,169 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testClient(t *testing.T, handler http.HandlerFunc) *Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return InitClient(server.URL, Options{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond})
+}
+
+func TestUpload(t *testing.T) {
+	content := strings.Repeat("a", 100000)
+	client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("upload")
+		if err != nil {
+			t.Fatalf("FormFile() error = %v", err)
+		}
+		data, _ := io.ReadAll(file)
+		if header.Filename != "report.pdf" || string(data) != content || r.FormValue("chunk-size") != "1MB" {
+			t.Errorf("received %s with %d bytes and chunk-size %q", header.Filename, len(data), r.FormValue("chunk-size"))
+		}
+		if header.Header.Get("Content-Type") != "application/pdf" || r.Header.Get("X-Meta-Author") != "jane" {
+			t.Errorf("received type %q and author %q", header.Header.Get("Content-Type"), r.Header.Get("X-Meta-Author"))
+		}
+		w.Write([]byte(`{"status":"success","ETag":"etag","versionId":"v1"}`))
+	})
+
+	var sent int64
+	result, err := client.Upload(context.Background(), "report.pdf", strings.NewReader(content), UploadOptions{
+		ChunkSize:   "1MB",
+		Progress:    func(n int64) { sent = n },
+		ContentType: "application/pdf",
+		Metadata:    map[string]string{"author": "jane"},
+	})
+	if err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	if result.VersionID != "v1" || result.ETag != "etag" {
+		t.Errorf("Upload() = %+v", result)
+	}
+	if sent != int64(len(content)) {
+		t.Errorf("progress reported %d bytes, want %d", sent, len(content))
+	}
+}
+
+func TestRetry(t *testing.T) {
+	calls := 0
+	client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(`{"versionId":"v1"}`))
+	})
+
+	// Readers that can seek are sent again
+	if _, err := client.Upload(context.Background(), "file", strings.NewReader("data"), UploadOptions{}); err != nil || calls != 3 {
+		t.Errorf("Upload() error = %v after %d calls, want success after 3", err, calls)
+	}
+
+	calls = 0
+	stream := io.MultiReader(strings.NewReader("data"))
+	if _, err := client.Upload(context.Background(), "file", stream, UploadOptions{}); !errors.Is(err, ErrUnavailable) || calls != 1 {
+		t.Errorf("Upload() of a stream error = %v after %d calls, want ErrUnavailable after 1", err, calls)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/file/missing/versions":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"Could not find file versions"}`))
+		case "/file/locked":
+			w.WriteHeader(http.StatusLocked)
+			w.Write([]byte(`{"message":"Cannot delete locked"}`))
+		}
+	})
+
+	_, err := client.Stat(context.Background(), "missing")
+	var apiErr *Error
+	if !errors.Is(err, ErrNotFound) || !errors.As(err, &apiErr) || apiErr.Message != "Could not find file versions" {
+		t.Errorf("Stat() error = %v, want ErrNotFound with the message of the server", err)
+	}
+	if _, err := client.Delete(context.Background(), "locked"); !errors.Is(err, ErrLocked) {
+		t.Errorf("Delete() error = %v, want ErrLocked", err)
+	}
+}
+
+func TestDownloadRange(t *testing.T) {
+	content := "0123456789"
+	client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/file/whole" {
+			// Like a proxy dropping the header
+			w.Write([]byte(content))
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	})
+
+	tests := []struct {
+		name   string
+		file   string
+		offset int64
+		length int64
+		want   string
+	}{
+		{"Middle", "file", 2, 3, "234"},
+		{"To the end", "file", 7, -1, "789"},
+		{"Range ignored", "whole", 2, 3, "234"},
+		{"Range ignored to the end", "whole", 7, -1, "789"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader, err := client.DownloadRange(context.Background(), tt.file, "", tt.offset, tt.length)
+			if err != nil {
+				t.Fatalf("DownloadRange() error = %v", err)
+			}
+			defer reader.Close()
+			if data, _ := io.ReadAll(reader); string(data) != tt.want {
+				t.Errorf("DownloadRange() = %q, want %q", data, tt.want)
+			}
+		})
+	}
+
+	if _, err := client.DownloadRange(context.Background(), "file", "", 20, 5); !errors.Is(err, ErrRangeNotSatisfiable) {
+		t.Errorf("DownloadRange() after the end error = %v, want ErrRangeNotSatisfiable", err)
+	}
+}
+
+func TestListAndShare(t *testing.T) {
+	client := testClient(t, func(w http.ResponseWriter, r *http.Request) {
+		// Slashes in names must stay escaped, the server matches routes on the escaped path
+		switch r.URL.EscapedPath() {
+		case "/files":
+			if r.URL.Query().Get("prefix") != "docs/" {
+				t.Errorf("List() sent prefix %q", r.URL.Query().Get("prefix"))
+			}
+			w.Write([]byte(`{"prefix":"docs/","files":[{"name":"docs/report.pdf","versionId":"v1","size":42}]}`))
+		case "/file/docs%2Freport.pdf/share":
+			if r.Method != http.MethodPost || r.URL.Query().Get("expires") != "1h0m0s" {
+				t.Errorf("Share() sent %s with expires %q", r.Method, r.URL.Query().Get("expires"))
+			}
+			w.Write([]byte(`{"path":"/shared/token","versionId":"v1","expiresAt":"2024-05-01T13:00:00Z"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+
+	files, err := client.List(context.Background(), "docs/")
+	if err != nil || len(files) != 1 || files[0].Name != "docs/report.pdf" || files[0].Size != 42 {
+		t.Errorf("List() = %+v, %v", files, err)
+	}
+	share, err := client.Share(context.Background(), "docs/report.pdf", "", time.Hour)
+	if err != nil || !strings.HasSuffix(share.URL, "/shared/token") || !strings.HasPrefix(share.URL, "http://") || share.VersionID != "v1" {
+		t.Errorf("Share() = %+v, %v", share, err)
+	}
+}
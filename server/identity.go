@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"slices"
+
+	"taurus-minio/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Client authenticated by its certificate
+type Identity struct {
+	// Common name of the certificate subject
+	CommonName string
+	// DNS names of the certificate
+	DNSNames []string
+}
+
+type identityKey struct{}
+
+// Returns the identity of the client certificate of the request, nil if the client sent none
+func IdentityFromContext(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
+
+func identityOf(certificate *x509.Certificate) *Identity {
+	return &Identity{
+		CommonName: certificate.Subject.CommonName,
+		DNSNames:   certificate.DNSNames,
+	}
+}
+
+// Whether the identity has one of the names
+func (identity *Identity) matches(names []string) bool {
+	if slices.Contains(names, identity.CommonName) {
+		return true
+	}
+	for _, name := range identity.DNSNames {
+		if slices.Contains(names, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Routes that answer without an allowed client certificate, so health probes work with clientAuth "request".
+// Share links are meant for clients outside the allow-list, the token authorizes them
+var publicRoutes = map[string]bool{"/healthz": true, "/readyz": true, "/shared/:token": true}
+
+// Gin middleware adding the identity of the verified client certificate to the request context and the logs.
+// If allowed is not empty, clients whose certificate has none of the names are refused with 403
+func IdentityMiddleware(allowed []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var identity *Identity
+		if state := c.Request.TLS; state != nil && len(state.VerifiedChains) > 0 {
+			identity = identityOf(state.VerifiedChains[0][0])
+			ctx := context.WithValue(c.Request.Context(), identityKey{}, identity)
+			ctx = logging.WithClient(ctx, identity.CommonName)
+			c.Request = c.Request.WithContext(ctx)
+		}
+		if len(allowed) > 0 && !publicRoutes[c.FullPath()] && (identity == nil || !identity.matches(allowed)) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"message": "Client certificate is not allowed",
+			})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"taurus-minio/client"
+)
+
+// Serves the TLS settings of the HTTP listener.
+// Certificate, key and client CA files are checked for changes and reloaded,
+// so renewed certificates are used without a restart. Connections that are already open keep their certificate
+type CertificateReloader struct {
+	configuration client.TLSConfiguration
+	current       atomic.Pointer[tls.Config]
+
+	// Serializes reloads
+	mu       sync.Mutex
+	modTimes map[string]time.Time
+}
+
+// Loads the files of the configuration, which must be enabled and valid
+func InitCertificateReloader(configuration client.TLSConfiguration) (*CertificateReloader, error) {
+	reloader := &CertificateReloader{
+		configuration: configuration,
+		modTimes:      make(map[string]time.Time),
+	}
+	if err := reloader.Reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// Configuration for the http.Server. Every handshake uses the settings loaded last.
+// The http.Server only adds its protocols to its own copy of the configuration, so they are set here
+func (reloader *CertificateReloader) Config() *tls.Config {
+	base := &tls.Config{
+		MinVersion: reloader.configuration.Version(),
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		loaded := reloader.current.Load()
+		config := base.Clone()
+		config.GetConfigForClient = nil
+		config.Certificates = loaded.Certificates
+		config.CipherSuites = loaded.CipherSuites
+		config.ClientAuth = loaded.ClientAuth
+		config.ClientCAs = loaded.ClientCAs
+		return config, nil
+	}
+	return base
+}
+
+func (reloader *CertificateReloader) files() []string {
+	files := []string{reloader.configuration.CertFile, reloader.configuration.KeyFile}
+	if reloader.configuration.ClientCAFile != "" {
+		files = append(files, reloader.configuration.ClientCAFile)
+	}
+	return files
+}
+
+// Starts checking the files for changes
+func (reloader *CertificateReloader) Start() {
+	go func() {
+		ticker := time.NewTicker(client.RELOAD_CHECK_INTERVAL)
+		defer ticker.Stop()
+		for range ticker.C {
+			if !reloader.changed() {
+				continue
+			}
+			if err := reloader.Reload(); err != nil {
+				slog.Error("Failed to reload TLS certificates, keeping the current ones", "error", err)
+				continue
+			}
+			slog.Info("Reloaded TLS certificates")
+		}
+	}()
+}
+
+func (reloader *CertificateReloader) changed() bool {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+	for _, file := range reloader.files() {
+		info, err := os.Stat(file)
+		if err == nil && !info.ModTime().Equal(reloader.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads the files and uses them for new connections.
+// Nothing changes if any of them can not be loaded
+func (reloader *CertificateReloader) Reload() error {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+	// Remember the state before reading, a file replaced while loading is read again on the next check
+	for _, file := range reloader.files() {
+		if info, err := os.Stat(file); err == nil {
+			reloader.modTimes[file] = info.ModTime()
+		}
+	}
+
+	conf := reloader.configuration
+	certificate, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+	if err != nil {
+		return fmt.Errorf("tls certificate: %w", err)
+	}
+	cipherSuites, err := conf.CipherSuiteIDs()
+	if err != nil {
+		return err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   conf.Version(),
+		CipherSuites: cipherSuites,
+		ClientAuth:   conf.ClientAuthType(),
+	}
+	if conf.ClientCAFile != "" {
+		data, err := os.ReadFile(conf.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("tls client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return errors.New("tls client CA: no certificates found in " + conf.ClientCAFile)
+		}
+		config.ClientCAs = pool
+	}
+	reloader.current.Store(config)
+	return nil
+}
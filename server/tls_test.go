@@ -0,0 +1,169 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"taurus-minio/client"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Creates a certificate for name signed by parent, self-signed if parent is nil
+func createCertificate(t *testing.T, name string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, any(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// Writes certificate and key as PEM files, returns their paths
+func writeCertificate(t *testing.T, dir string, name string, certificate tls.Certificate) (string, string) {
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	key, err := x509.MarshalECPrivateKey(certificate.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Certificate[0]}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestMutualTLS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	ca := createCertificate(t, "test-ca", nil)
+	caFile, _ := writeCertificate(t, dir, "ca", ca)
+	certFile, keyFile := writeCertificate(t, dir, "server", createCertificate(t, "server", &ca))
+	allowed := createCertificate(t, "allowed-client", &ca)
+	other := createCertificate(t, "other-client", &ca)
+
+	configuration := client.TLSConfiguration{
+		Enabled:        true,
+		CertFile:       certFile,
+		KeyFile:        keyFile,
+		MinVersion:     "1.2",
+		ClientAuth:     "request",
+		ClientCAFile:   caFile,
+		AllowedClients: []string{"allowed-client"},
+	}
+	certificates, err := InitCertificateReloader(configuration)
+	if err != nil {
+		t.Fatalf("InitCertificateReloader() error = %v", err)
+	}
+
+	router := gin.New()
+	router.Use(IdentityMiddleware(configuration.AllowedClients))
+	router.GET("/file/:name", func(c *gin.Context) {
+		c.String(http.StatusOK, IdentityFromContext(c.Request.Context()).CommonName)
+	})
+	router.GET("/healthz", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/shared/:token", func(c *gin.Context) { c.Status(http.StatusOK) })
+	server := httptest.NewUnstartedServer(router)
+	server.TLS = certificates.Config()
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	get := func(path string, certificate *tls.Certificate) (int, *x509.Certificate) {
+		config := &tls.Config{RootCAs: roots}
+		if certificate != nil {
+			config.Certificates = []tls.Certificate{*certificate}
+		}
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		response, err := httpClient.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s error = %v", path, err)
+		}
+		response.Body.Close()
+		return response.StatusCode, response.TLS.PeerCertificates[0]
+	}
+
+	if status, _ := get("/file/a", &allowed); status != http.StatusOK {
+		t.Errorf("allowed client got status %d", status)
+	}
+	if status, _ := get("/file/a", &other); status != http.StatusForbidden {
+		t.Errorf("other client got status %d, want 403", status)
+	}
+	if status, _ := get("/file/a", nil); status != http.StatusForbidden {
+		t.Errorf("client without certificate got status %d, want 403", status)
+	}
+	if status, _ := get("/healthz", nil); status != http.StatusOK {
+		t.Errorf("health probe without certificate got status %d", status)
+	}
+	if status, _ := get("/shared/token", &other); status != http.StatusOK {
+		t.Errorf("share link with other client got status %d", status)
+	}
+
+	// The reloaded settings keep the protocols of the server
+	conn, err := tls.Dial("tcp", server.Listener.Addr().String(), &tls.Config{RootCAs: roots, NextProtos: []string{"h2"}})
+	if err != nil {
+		t.Fatalf("tls.Dial() error = %v", err)
+	}
+	if protocol := conn.ConnectionState().NegotiatedProtocol; protocol != "h2" {
+		t.Errorf("negotiated protocol = %q, want h2", protocol)
+	}
+	conn.Close()
+
+	// Renewed certificates are used for new connections
+	renewed := createCertificate(t, "server", &ca)
+	writeCertificate(t, dir, "server", renewed)
+	if err := certificates.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if _, served := get("/healthz", nil); served.SerialNumber.Cmp(renewed.Leaf.SerialNumber) != 0 {
+		t.Error("server still uses the old certificate after reload")
+	}
+
+	// A broken file keeps the current certificate
+	os.WriteFile(keyFile, []byte("broken"), 0o600)
+	if err := certificates.Reload(); err == nil {
+		t.Error("Reload() of a broken key succeeded")
+	}
+	if _, served := get("/healthz", nil); served.SerialNumber.Cmp(renewed.Leaf.SerialNumber) != 0 {
+		t.Error("server changed the certificate after a failed reload")
+	}
+}
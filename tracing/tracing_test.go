@@ -0,0 +1,85 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"taurus-minio/client"
+
+	"github.com/gin-gonic/gin"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// Stand-in for an OTLP/HTTP collector, keeps every exported span
+type testCollector struct {
+	mu    sync.Mutex
+	spans map[string]string // span name -> trace id
+}
+
+func (collector *testCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	var request coltracepb.ExportTraceServiceRequest
+	if err := proto.Unmarshal(body, &request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	for _, resourceSpans := range request.ResourceSpans {
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			for _, span := range scopeSpans.Spans {
+				collector.spans[span.Name] = hex.EncodeToString(span.TraceId)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestMiddlewarePropagatesTraceContext(t *testing.T) {
+	collector := &testCollector{spans: make(map[string]string)}
+	server := httptest.NewServer(collector)
+	defer server.Close()
+
+	shutdown, err := Init(client.TracingConfiguration{
+		Enabled:  true,
+		Endpoint: strings.TrimPrefix(server.URL, "http://"),
+		Insecure: true,
+	})
+	if err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Middleware())
+	router.GET("/file/:name", func(c *gin.Context) {
+		_, span := Start(c.Request.Context(), "decrypt")
+		span.End()
+		c.Status(http.StatusOK)
+	})
+
+	traceId := "4bf92f3577b34da6a3ce929d0e0e4736"
+	request := httptest.NewRequest(http.MethodGet, "/file/big.txt", nil)
+	request.Header.Set("traceparent", "00-"+traceId+"-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), request)
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown() error = %v", err)
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	for _, name := range []string{"GET /file/:name", "decrypt"} {
+		if got := collector.spans[name]; got != traceId {
+			t.Errorf("span %q trace id = %q, want = %q", name, got, traceId)
+		}
+	}
+}